@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package config - config
+// Contains the per-repository configuration read from .ci-brakeman.yml
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/ci-brakeman/policy"
+	"gopkg.in/yaml.v2"
+)
+
+// FileName is the name of the configuration file in the root of a repository
+const FileName = ".ci-brakeman.yml"
+
+// the commits of a pull request that can be scanned, see Config.ScanTarget
+const (
+	// TargetHead scans the head of the pull request
+	TargetHead = "head"
+	// TargetMerge scans the result of merging the pull request into its base
+	TargetMerge = "merge"
+	// TargetBoth scans the head and the merge commit
+	TargetBoth = "both"
+)
+
+// allowedFlags are the brakeman options a repository may add to the scan,
+// along with whether they take a value. Options that change where brakeman
+// reads or writes files are not allowed.
+var allowedFlags = map[string]bool{
+	"-A": false, "--run-all-checks": false,
+	"-t": true, "--test": true,
+	"-x": true, "--except": true,
+	"-w1": false, "-w2": false, "-w3": false, "--confidence-level": true,
+	"--rails3": false, "--rails4": false, "--rails5": false, "--rails6": false, "--rails7": false,
+	"--skip-libs": false, "--no-threads": false, "--faster": false, "--interprocedural": false,
+	"--no-assume-routes": false, "--escape-html": false, "--branch-limit": true, "--parser-timeout": true,
+	"--safe-methods": true, "--sql-safe-methods": true, "--url-safe-methods": true,
+	"--only-files": true, "--skip-files": true,
+}
+
+// Config holds the settings of a single repository
+type Config struct {
+	// Policy decides the conclusion of the check run, unset fields keep the server defaults
+	Policy policy.Policy `yaml:"policy"`
+	// ExcludePaths are not scanned, they are relative to the app path. Directories end with a /.
+	ExcludePaths []string `yaml:"exclude_paths"`
+	// BrakemanFlags are added to the brakeman command line
+	BrakemanFlags []string `yaml:"brakeman_flags"`
+	// AppPath is the directory of the Rails app, relative to the root of the repository
+	AppPath string `yaml:"app_path"`
+	// AppPaths are the directories of further Rails apps. When neither AppPath
+	// nor AppPaths are set, the apps in the repository are discovered.
+	AppPaths []string `yaml:"app_paths"`
+	// Comment controls the comment posted on pull requests
+	Comment Comment `yaml:"comment"`
+	// Notify controls who gets mentioned in the comment
+	Notify Notify `yaml:"notify"`
+	// ScanTarget is the commit of a pull request that gets scanned: head,
+	// merge or both. Pushes always scan the pushed commit.
+	ScanTarget string `yaml:"scan_target"`
+}
+
+// Comment controls the comment posted on pull requests
+type Comment struct {
+	// Enabled turns the comment on or off, the check run is always created
+	Enabled bool `yaml:"enabled"`
+	// OnlyOnWarnings only comments when the pull request introduces warnings
+	OnlyOnWarnings bool `yaml:"only_on_warnings"`
+	// Throttle posts a new comment at most once per DIFF_MINUTES, changed
+	// findings in between update the last comment
+	Throttle bool `yaml:"throttle"`
+}
+
+// Notify controls who gets mentioned in the comment
+type Notify struct {
+	// Mentions are users or teams, e.g. @octocat or @octo-org/security
+	Mentions []string `yaml:"mentions"`
+	// On is either "violations", to mention when the policy is violated,
+	// or "new_warnings", to mention whenever the pull request introduces warnings
+	On string `yaml:"on"`
+}
+
+// Default returns the configuration used for repositories without a
+// configuration file, with the given policy
+func Default(p policy.Policy) *Config {
+	return &Config{
+		Policy:     p,
+		Comment:    Comment{Enabled: true},
+		Notify:     Notify{On: "violations"},
+		ScanTarget: TargetHead,
+	}
+}
+
+// Parse reads a configuration file. Settings missing from the file keep
+// the values of the defaults.
+func Parse(data []byte, defaults *Config) (*Config, error) {
+	cfg := *defaults
+	// lists set in the file replace the default lists
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("Couldn't parse %s: %s", FileName, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid %s: %s", FileName, err)
+	}
+	return &cfg, nil
+}
+
+// Validate checks that the configuration can safely be used for a scan
+func (c *Config) Validate() error {
+	if err := c.Policy.Validate(); err != nil {
+		return err
+	}
+	if c.AppPath != "" && !isRelative(c.AppPath) {
+		return fmt.Errorf("app_path %q has to be a path inside the repository", c.AppPath)
+	}
+	for _, p := range c.AppPaths {
+		if !isRelative(p) || strings.Contains(p, ",") {
+			return fmt.Errorf("app_paths entry %q has to be a path inside the repository", p)
+		}
+	}
+	for _, p := range c.ExcludePaths {
+		if !isRelative(p) || strings.Contains(p, ",") {
+			return fmt.Errorf("exclude_paths entry %q has to be a path inside the repository", p)
+		}
+	}
+	// the flag that still needs its value
+	pending := ""
+	for _, f := range c.BrakemanFlags {
+		if !strings.HasPrefix(f, "-") {
+			if pending == "" {
+				return fmt.Errorf("brakeman flag value %q doesn't follow a flag taking a value", f)
+			}
+			pending = ""
+			continue
+		}
+		if pending != "" {
+			return fmt.Errorf("brakeman flag %q needs a value", pending)
+		}
+		parts := strings.SplitN(f, "=", 2)
+		takesValue, ok := allowedFlags[parts[0]]
+		switch {
+		case !ok:
+			return fmt.Errorf("brakeman flag %q is not allowed", parts[0])
+		case !takesValue && len(parts) == 2:
+			return fmt.Errorf("brakeman flag %q takes no value", parts[0])
+		case takesValue && len(parts) == 1:
+			pending = parts[0]
+		}
+	}
+	if pending != "" {
+		return fmt.Errorf("brakeman flag %q needs a value", pending)
+	}
+	if c.Notify.On != "violations" && c.Notify.On != "new_warnings" {
+		return fmt.Errorf("notify.on %q has to be violations or new_warnings", c.Notify.On)
+	}
+	if c.ScanTarget != TargetHead && c.ScanTarget != TargetMerge && c.ScanTarget != TargetBoth {
+		return fmt.Errorf("scan_target %q has to be head, merge or both", c.ScanTarget)
+	}
+	return nil
+}
+
+// WithProtected returns a copy of the configuration with the settings that
+// decide what is scanned, what passes and who is told about it taken from
+// base: the policy, the excluded paths, the brakeman flags, the app paths
+// and the notifications. A pull request could otherwise loosen its own check
+// in the same change that adds a vulnerability.
+func (c *Config) WithProtected(base *Config) *Config {
+	cfg := *c
+	cfg.Policy = base.Policy
+	cfg.ExcludePaths = base.ExcludePaths
+	cfg.BrakemanFlags = base.BrakemanFlags
+	cfg.AppPath = base.AppPath
+	cfg.AppPaths = base.AppPaths
+	cfg.Notify = base.Notify
+	return &cfg
+}
+
+// Apps returns the configured directories of the Rails apps, empty to discover them
+func (c *Config) Apps() []string {
+	var apps []string
+	if c.AppPath != "" {
+		apps = append(apps, c.AppPath)
+	}
+	return append(apps, c.AppPaths...)
+}
+
+// isRelative reports whether p is a relative path that stays inside the repository
+func isRelative(p string) bool {
+	clean := path.Clean(p)
+	return !path.IsAbs(clean) && clean != ".." && !strings.HasPrefix(clean, "../")
+}
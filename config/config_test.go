@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ci-brakeman/policy"
+)
+
+func TestWithProtected(t *testing.T) {
+	base, err := Parse([]byte(`
+policy:
+  min_confidence: Medium
+exclude_paths: [spec/]
+brakeman_flags: [--skip-libs]
+app_path: web
+app_paths: [engines/billing]
+notify:
+  mentions: ["@octo-org/security"]
+  on: new_warnings
+comment:
+  enabled: true
+scan_target: head
+`), Default(policy.Default()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := Parse([]byte(`
+policy:
+  max_warnings: 100
+exclude_paths: [app/]
+brakeman_flags: [-x, CheckSQL]
+app_path: dummy
+app_paths: []
+notify:
+  mentions: []
+  on: violations
+comment:
+  enabled: false
+  only_on_warnings: true
+scan_target: merge
+`), Default(policy.Default()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := head.WithProtected(base)
+
+	want := *base
+	// settings that don't decide what is scanned or what passes come from the head
+	want.Comment = head.Comment
+	want.ScanTarget = head.ScanTarget
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("WithProtected() = %+v, want %+v", *got, want)
+	}
+	if head.AppPath != "dummy" || head.Policy.MaxWarnings != 100 {
+		t.Errorf("WithProtected() modified the head configuration: %+v", *head)
+	}
+}
+
+func TestWithProtectedKeepsDiscovery(t *testing.T) {
+	base := Default(policy.Default())
+	head := Default(policy.Default())
+	head.AppPath = "dummy"
+	head.AppPaths = []string{"empty"}
+
+	if apps := head.WithProtected(base).Apps(); len(apps) != 0 {
+		t.Errorf("Apps() = %q, want the apps to be discovered like on the base", apps)
+	}
+}
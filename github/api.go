@@ -9,110 +9,76 @@ package github
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
-	"net/http"
+	"iter"
+	"net/url"
 	"os"
-	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"gopkg.in/src-d/go-git.v4"
+	gitConfig "gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 	gitHttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
 )
 
-// JWTToken holds the access token used to auth to Github
-var JWTToken string
+// CheckRunName is the name under which scan results show up on a commit
+const CheckRunName = "Brakeman Scan (Security)"
 
-// GithubToken holds the access token for GitHub when not using a bearer token
-var GithubToken string
+// maxAnnotationsPerRequest is the number of annotations the Checks API accepts per request
+const maxAnnotationsPerRequest = 50
 
-const githubAPIHost = "https://api.github.com"
+// GetAccessToken returns a Access token for interacting with Github on behalf
+// of the given installation of the GitHub App
+func (c *Client) GetAccessToken(installationID string) (accessToken *TokenResponse, err error) {
 
-func makeGetRequest(path string) (resp []byte, statusCode int, err error) {
-	return makeRequest(path, "GET", false, nil)
-}
-
-func makePostRequest(path string, data io.Reader) (resp []byte, statusCode int, err error) {
-	return makeRequest(path, "POST", false, data)
-}
-
-func makeAuthRequest(path string) (resp []byte, statusCode int, err error) {
-	return makeRequest(path, "POST", true, nil)
-}
-
-func makeRequest(path, method string, isAuth bool, data io.Reader) (resp []byte, statusCode int, err error) {
-	var request *http.Request
-	url := fmt.Sprintf("%s%s", githubAPIHost, path)
-	request, err = http.NewRequest(method, url, data)
-
-	if err != nil {
-		//logger.Error(err)
-		return
-	}
-	var response *http.Response
-
-	// setting header to vnd.github.machine-man-preview since we are a github app
-	// only for AUTH
-	if isAuth {
-		request.Header.Add("Accept", "application/vnd.github.machine-man-preview+json")
-		request.Header.Add("Authorization", "bearer "+JWTToken)
-	} else {
-		request.Header.Add("Accept", "application/vnd.github.symmetra-preview+json")
-		request.Header.Add("Authorization", "token "+GithubToken)
-	}
-
-	response, err = (&http.Client{}).Do(request)
+	path := fmt.Sprintf("/app/installations/%s/access_tokens", installationID)
+	// only the GitHub App itself can request installation tokens
+	body, status, err := c.request(path, "POST", "application/vnd.github.machine-man-preview+json", nil)
 
 	if err != nil {
 		return
 	}
 
-	defer response.Body.Close()
-
-	if err != nil {
-		return
+	if status != 201 {
+		return nil, fmt.Errorf("Auth failed with status code: %d", status)
 	}
 
-	resp, err = ioutil.ReadAll(response.Body)
-	if err != nil {
-		return
+	if err = json.Unmarshal(body, &accessToken); err != nil {
+		return nil, err
 	}
-	// return status code so that api function knows what the outcome was
-	statusCode = response.StatusCode
 	return
 }
 
-// GetAccessToken returns a Access token for interacting with Github
-func GetAccessToken(installationID string) (err error) {
-	var accessToken TokenResponse
-
-	path := fmt.Sprintf("/app/installations/%s/access_tokens", installationID)
-	body, status, err := makeAuthRequest(path)
-
+// GetApp returns the GitHub App the client authenticates as
+// Github API docs: https://docs.github.com/en/rest/reference/apps#get-the-authenticated-app
+func (c *Client) GetApp() (app *App, err error) {
+	// only the GitHub App itself can fetch its metadata
+	body, status, err := c.request("/app", "GET", "application/vnd.github.machine-man-preview+json", nil)
 	if err != nil {
 		return
 	}
 
-	if status != 201 {
-		return fmt.Errorf("Auth failed with status code: %d", status)
+	if status != 200 {
+		return nil, fmt.Errorf("Fetch failed with status code: %d", status)
 	}
 
-	if err = json.Unmarshal(body, &accessToken); err != nil {
-		return
+	if err = json.Unmarshal(body, &app); err != nil {
+		return nil, err
 	}
-	GithubToken = accessToken.Token
 	return
 }
 
 // GetCommit returns a specific commit
 // Github API docs: https://developer.github.com/v3/repos/commits/#get-a-single-commit
-func GetCommit(owner, repo, sha string) (commit *RepoCommit, resp *Response, err error) {
+func (c *Client) GetCommit(owner, repo, sha string) (commit *RepoCommit, resp *Response, err error) {
 	path := fmt.Sprintf("/repos/%v/%v/commits/%v", owner, repo, sha)
-	data, status, err := makeGetRequest(path)
+	data, status, err := c.makeGetRequest(path)
 
 	if err != nil {
 		return
@@ -134,13 +100,13 @@ func GetCommit(owner, repo, sha string) (commit *RepoCommit, resp *Response, err
 
 // GetContents returns the contents of a file
 // Github API docs: https://developer.github.com/v3/repos/contents/#get-contents
-func GetContents(owner, repo, path, ref string) (content *Content, resp *Response, err error) {
+func (c *Client) GetContents(owner, repo, path, ref string) (content *Content, resp *Response, err error) {
 	// ref can be empty
 	p := fmt.Sprintf("/repos/%s/%s/contents/%s", owner, repo, path)
 	if ref != "" {
 		p = fmt.Sprintf("/repos/%s/%s/contents/%s?ref=%s", owner, repo, path, ref)
 	}
-	data, status, err := makeGetRequest(p)
+	data, status, err := c.makeGetRequest(p)
 
 	if err != nil {
 		return
@@ -163,51 +129,139 @@ func GetContents(owner, repo, path, ref string) (content *Content, resp *Respons
 // GetFileFromTree returns the Blob content of a given file from a tree in a repository
 // https://developer.github.com/v3/git/trees/
 // https://developer.github.com/v3/git/blobs/
-func GetFileFromTree(owner, repo, fp, ref string) (content *Blob, resp *Response, err error) {
-
-	// if path is a file in a sub-dir, we have to walk the tree to get to the file
-	fpath, fname := path.Split(fp)
+func (c *Client) GetFileFromTree(owner, repo, fp, ref string) (content *Blob, resp *Response, err error) {
+	// the recursive tree holds the files of all sub-dirs, with their full path
 	downloadPath := ""
+	for t, err := range c.TreeEntries(owner, repo, ref) {
+		if err != nil {
+			return nil, nil, err
+		}
+		if t.Path != nil && *t.Path == fp && t.URL != nil {
+			downloadPath = *t.URL
+			break
+		}
+	}
+	if downloadPath == "" {
+		return nil, nil, fmt.Errorf("%s not found in %s", fp, ref)
+	}
+	// replace prefix in the downloadPath since makeGetRequest prepends that by default
+	downloadPath = strings.TrimPrefix(downloadPath, c.BaseURL)
 
+	data, status, err := c.makeGetRequest(downloadPath)
 	if err != nil {
 		return
 	}
-	var tree *Tree
 
-	nxsha := ref // start walking from the user supplied ref
-	for _, k := range strings.Split(fpath, "/") {
-		// get the tree for current ref
-		tree, resp, err = GetTree(owner, repo, nxsha)
+	// unmarshal the data, this is a Blob. The blob contains the base64 encoded data of the file
+	if status != 200 {
+		if err = json.Unmarshal(data, &resp); err != nil {
+			return nil, nil, fmt.Errorf("Fetch failed with status code: %d and couldn't unmarshal response: %s", status, err)
+		}
+		return nil, resp, fmt.Errorf("Fetch failed with status code: %d", status)
+	}
+
+	if err = json.Unmarshal(data, &content); err != nil {
+		return nil, nil, fmt.Errorf("Couldn't unmarshal response: %s", err)
+	}
+	return
+}
+
+// GetTree returns the contents of a Tree on GitHub, without the contents of its sub-trees
+// https://developer.github.com/v3/git/trees/
+func (c *Client) GetTree(owner, repo, ref string) (tree *Tree, resp *Response, err error) {
+	return c.getTree(owner, repo, ref, false)
+}
+
+// GetTreeRecursive returns the contents of a Tree on GitHub including all its
+// sub-trees, entries have the path from the root of the tree. GitHub truncates
+// large recursive trees, those are completed by fetching the sub-trees.
+func (c *Client) GetTreeRecursive(owner, repo, ref string) (tree *Tree, resp *Response, err error) {
+	tree, resp, err = c.getTree(owner, repo, ref, true)
+	if err != nil || tree.Truncated == nil || !*tree.Truncated {
+		return
+	}
+
+	c.logf("[GetTreeRecursive] tree %s of %s/%s is truncated, walking its sub-trees", ref, owner, repo)
+	if tree.Entries, err = c.walkTree(owner, repo, ref, ""); err != nil {
+		return nil, nil, err
+	}
+	complete := false
+	tree.Truncated = &complete
+	return
+}
+
+// TreeEntries iterates over the entries of a tree and all its sub-trees
+func (c *Client) TreeEntries(owner, repo, ref string) iter.Seq2[*TreeEntry, error] {
+	return func(yield func(*TreeEntry, error) bool) {
+		tree, _, err := c.GetTreeRecursive(owner, repo, ref)
 		if err != nil {
-			fmt.Println(resp)
+			yield(nil, err)
+			return
 		}
-		// parse all entries in the tree to find the ref to
-		// the path we are trying to walk
 		for _, t := range tree.Entries {
-			// check if we've found the ref for the next tree to walk
-			if *t.Path == k {
-				nxsha = *t.SHA
-				break
+			if !yield(t, nil) {
+				return
 			}
 		}
 	}
-	// reached the end of the tree and now we want to
-	// get the download path to the actual file to download
+}
+
+// walkTree returns the entries of a tree and its sub-trees, with prefix added
+// to their paths. Sub-trees are fetched recursively, and walked one level at a
+// time only if they are truncated as well.
+func (c *Client) walkTree(owner, repo, sha, prefix string) (entries []*TreeEntry, err error) {
+	tree, _, err := c.getTree(owner, repo, sha, false)
+	if err != nil {
+		return
+	}
+	if tree.Truncated != nil && *tree.Truncated {
+		return nil, fmt.Errorf("tree %s of %s/%s has too many entries", sha, owner, repo)
+	}
+
 	for _, t := range tree.Entries {
-		if *t.Path == fname {
-			downloadPath = *t.URL
-			break
+		entry := *t
+		p := prefix + *t.Path
+		entry.Path = &p
+		entries = append(entries, &entry)
+
+		if t.Type == nil || *t.Type != "tree" {
+			continue
+		}
+		sub, _, err := c.getTree(owner, repo, *t.SHA, true)
+		if err != nil {
+			return nil, err
+		}
+		if sub.Truncated != nil && *sub.Truncated {
+			subEntries, err := c.walkTree(owner, repo, *t.SHA, p+"/")
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, subEntries...)
+			continue
+		}
+		for _, s := range sub.Entries {
+			subEntry := *s
+			sp := p + "/" + *s.Path
+			subEntry.Path = &sp
+			entries = append(entries, &subEntry)
 		}
 	}
-	// replace prefix in the downloadPath since makeGetRequest prepends that by default
-	downloadPath = strings.Replace(downloadPath, "https://api.github.com", "", -1)
+	return
+}
+
+func (c *Client) getTree(owner, repo, ref string, recursive bool) (tree *Tree, resp *Response, err error) {
+
+	p := fmt.Sprintf("/repos/%s/%s/git/trees/%s", owner, repo, ref)
+	if recursive {
+		p += "?recursive=1"
+	}
+
+	data, status, err := c.makeGetRequest(p)
 
-	data, status, err := makeGetRequest(downloadPath)
 	if err != nil {
 		return
 	}
 
-	// unmarshal the data, this is a Blob. The blob contains the base64 encoded data of the file
 	if status != 200 {
 		if err = json.Unmarshal(data, &resp); err != nil {
 			return nil, nil, fmt.Errorf("Fetch failed with status code: %d and couldn't unmarshal response: %s", status, err)
@@ -215,19 +269,44 @@ func GetFileFromTree(owner, repo, fp, ref string) (content *Blob, resp *Response
 		return nil, resp, fmt.Errorf("Fetch failed with status code: %d", status)
 	}
 
-	if err = json.Unmarshal(data, &content); err != nil {
+	if err = json.Unmarshal(data, &tree); err != nil {
 		return nil, nil, fmt.Errorf("Couldn't unmarshal response: %s", err)
 	}
+
 	return
 }
 
-// GetTree returns the contents of a Tree on GitHub
-// https://developer.github.com/v3/git/trees/
-func GetTree(owner, repo, ref string) (tree *Tree, resp *Response, err error) {
+// GetBranch returns a branch of a repository
+// Github API docs: https://docs.github.com/en/rest/reference/repos#get-a-branch
+func (c *Client) GetBranch(owner, repo, branch string) (b *Branch, resp *Response, err error) {
+	p := fmt.Sprintf("/repos/%s/%s/branches/%s", owner, repo, branch)
 
-	p := fmt.Sprintf("/repos/%s/%s/git/trees/%s", owner, repo, ref)
+	data, status, err := c.makeGetRequest(p)
 
-	data, status, err := makeGetRequest(p)
+	if err != nil {
+		return
+	}
+
+	if status != 200 {
+		if err = json.Unmarshal(data, &resp); err != nil {
+			return nil, nil, fmt.Errorf("Fetch failed with status code: %d and couldn't unmarshal response: %s", status, err)
+		}
+		return nil, resp, fmt.Errorf("Fetch failed with status code: %d", status)
+	}
+
+	if err = json.Unmarshal(data, &b); err != nil {
+		return nil, nil, fmt.Errorf("Couldn't unmarshal response: %s", err)
+	}
+
+	return
+}
+
+// GetPullRequest returns a single pull request
+// Github API docs: https://docs.github.com/en/rest/reference/pulls#get-a-pull-request
+func (c *Client) GetPullRequest(owner, repo string, number int) (pr *PullRequest, resp *Response, err error) {
+	p := fmt.Sprintf("/repos/%s/%s/pulls/%d", owner, repo, number)
+
+	data, status, err := c.makeGetRequest(p)
 
 	if err != nil {
 		return
@@ -240,163 +319,419 @@ func GetTree(owner, repo, ref string) (tree *Tree, resp *Response, err error) {
 		return nil, resp, fmt.Errorf("Fetch failed with status code: %d", status)
 	}
 
-	if err = json.Unmarshal(data, &tree); err != nil {
+	if err = json.Unmarshal(data, &pr); err != nil {
 		return nil, nil, fmt.Errorf("Couldn't unmarshal response: %s", err)
 	}
 
 	return
 }
 
-// GetPullRequestFiles gets the files for a particular pull request
-func GetPullRequestFiles(owner, repo, pullNumber string) (pullReqResp []PullRequestFile, err error) {
-	path := fmt.Sprintf("/repos/%v/%v/pulls/%v/files", owner, repo, pullNumber)
-	url := fmt.Sprintf("%s%s", githubAPIHost, path)
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "token "+GithubToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// CommitPullRequests iterates over the pull requests a commit belongs to
+// Github API docs: https://docs.github.com/en/rest/reference/repos#list-pull-requests-associated-with-a-commit
+func (c *Client) CommitPullRequests(owner, repo, sha string) iter.Seq2[PullRequest, error] {
+	return paginate[PullRequest](c, fmt.Sprintf("/repos/%s/%s/commits/%s/pulls", owner, repo, sha))
+}
+
+// GetCommitPullRequests returns the pull requests a commit belongs to
+func (c *Client) GetCommitPullRequests(owner, repo, sha string) (prs []PullRequest, resp *Response, err error) {
+	prs, err = collect(c.CommitPullRequests(owner, repo, sha))
+	return
+}
+
+// GetCollaboratorPermission returns the permission a user has on a repository
+// Github API docs: https://docs.github.com/en/rest/reference/repos#get-repository-permissions-for-a-user
+func (c *Client) GetCollaboratorPermission(owner, repo, user string) (perm *CollaboratorPermission, resp *Response, err error) {
+	p := fmt.Sprintf("/repos/%s/%s/collaborators/%s/permission", owner, repo, user)
+
+	data, status, err := c.makeGetRequest(p)
+
 	if err != nil {
-		panic(err)
+		return
+	}
+
+	if status != 200 {
+		if err = json.Unmarshal(data, &resp); err != nil {
+			return nil, nil, fmt.Errorf("Fetch failed with status code: %d and couldn't unmarshal response: %s", status, err)
+		}
+		return nil, resp, fmt.Errorf("Fetch failed with status code: %d", status)
 	}
-	defer resp.Body.Close()
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+
+	if err = json.Unmarshal(data, &perm); err != nil {
+		return nil, nil, fmt.Errorf("Couldn't unmarshal response: %s", err)
+	}
+
+	return
+}
+
+// UpdateContents creates or replaces a file in a repository with a new commit
+// Github API docs: https://docs.github.com/en/rest/reference/repos#create-or-update-file-contents
+func (c *Client) UpdateContents(owner, repo, path string, update *ContentUpdate) (result *ContentUpdateResponse, resp *Response, err error) {
+	p := fmt.Sprintf("/repos/%s/%s/contents/%s", owner, repo, path)
+
+	body, err := json.Marshal(update)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
-	if err = json.Unmarshal(bodyBytes, &pullReqResp); err != nil {
-		return nil, fmt.Errorf("Couldn't unmarshal response: %s", err)
+
+	data, status, err := c.makeRequest(p, "PUT", bytes.NewReader(body))
+
+	if err != nil {
+		return
+	}
+
+	if status != 200 && status != 201 {
+		if err = json.Unmarshal(data, &resp); err != nil {
+			return nil, nil, fmt.Errorf("Update failed with status code: %d and couldn't unmarshal response: %s", status, err)
+		}
+		return nil, resp, fmt.Errorf("Update failed with status code: %d: %s", status, resp.Message)
+	}
+
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, nil, fmt.Errorf("Couldn't unmarshal response: %s", err)
 	}
 
-	fmt.Println("[GetPullRequestFiles]: response Status:", resp.Status, "repo: ", repo, "owner: ", owner, "pull request number: ", pullNumber)
+	return
+}
 
+// PullRequestFiles iterates over the files of a pull request
+// Github API docs: https://docs.github.com/en/rest/reference/pulls#list-pull-requests-files
+func (c *Client) PullRequestFiles(owner, repo, pullNumber string) iter.Seq2[PullRequestFile, error] {
+	return paginate[PullRequestFile](c, fmt.Sprintf("/repos/%v/%v/pulls/%v/files", owner, repo, pullNumber))
+}
+
+// GetPullRequestFiles gets the files for a particular pull request
+func (c *Client) GetPullRequestFiles(owner, repo, pullNumber string) (pullReqResp []PullRequestFile, err error) {
+	pullReqResp, err = collect(c.PullRequestFiles(owner, repo, pullNumber))
+	c.logf("[GetPullRequestFiles]: %d files repo: %s owner: %s pull request number: %s", len(pullReqResp), repo, owner, pullNumber)
 	return
 }
 
-//PostCommentToGit posts comments to Pull requests
-func PostCommentToGit(owner string, repo string, pullNumber string, commentBody string) (err error) {
+// PostCommentToGit posts comments to Pull requests
+// Github API docs: https://docs.github.com/en/rest/reference/issues#create-an-issue-comment
+func (c *Client) PostCommentToGit(owner string, repo string, pullNumber string, commentBody string) (err error) {
 	path := fmt.Sprintf("/repos/%v/%v/issues/%v/comments", owner, repo, pullNumber)
 
-	var jsonStr = []byte(commentBody)
-	url := fmt.Sprintf("%s%s", githubAPIHost, path)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
-	req.Header.Set("Authorization", "token "+GithubToken)
-	req.Header.Set("Content-Type", "application/json")
+	body, err := json.Marshal(map[string]string{"body": commentBody})
+	if err != nil {
+		return
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	data, status, err := c.makePostRequest(path, bytes.NewReader(body))
 	if err != nil {
-		panic(err)
+		return
 	}
-	defer resp.Body.Close()
 
-	fmt.Println("[PostCommentToGit] response Status: ", resp.Status, "repo: ", repo, "owner: ", owner, "pull request number: ", pullNumber)
+	c.logf("[PostCommentToGit] response Status: %d repo: %s owner: %s pull request number: %s", status, repo, owner, pullNumber)
 
+	if status != 201 {
+		var resp Response
+		if e := json.Unmarshal(data, &resp); e == nil && resp.Message != "" {
+			return fmt.Errorf("Comment failed with status code: %d: %s", status, resp.Message)
+		}
+		return fmt.Errorf("Comment failed with status code: %d", status)
+	}
 	return
 }
 
-// CreateGitCheckRun creates a PR Check
-func CreateGitCheckRun(owner string, repo string, commitSHA string) (checkRunID string) {
-	fmt.Println(GithubToken)
-	path := fmt.Sprintf("/repos/%v/%v/check-runs", owner, repo)
-	body := `{
-		"name": "Brakeman Scan (Security)",
-		"head_sha": "` + commitSHA + `",
-		"status": "in_progress",
-		"external_id": "03",
-		"started_at": "` + time.Now().Format(time.RFC3339) + `",
-		"output": {
-			"title": "Brakeman Scan",
-			"summary": "Scanning the commits in this pull request for potential security vulnerabilities",
-			"text": ""
+// IssueComments iterates over the comments on an issue or pull request, oldest first
+// Github API docs: https://docs.github.com/en/rest/reference/issues#list-issue-comments
+func (c *Client) IssueComments(owner, repo string, number int) iter.Seq2[IssueComment, error] {
+	return paginate[IssueComment](c, fmt.Sprintf("/repos/%s/%s/issues/%d/comments", owner, repo, number))
+}
+
+// ListIssueComments returns the comments on an issue or pull request, oldest first
+func (c *Client) ListIssueComments(owner, repo string, number int) (comments []IssueComment, resp *Response, err error) {
+	comments, err = collect(c.IssueComments(owner, repo, number))
+	return
+}
+
+// UpdateIssueComment replaces the body of a comment on an issue or pull request
+// Github API docs: https://docs.github.com/en/rest/reference/issues#update-an-issue-comment
+func (c *Client) UpdateIssueComment(owner, repo string, commentID int64, commentBody string) (err error) {
+	p := fmt.Sprintf("/repos/%s/%s/issues/comments/%d", owner, repo, commentID)
+
+	body, err := json.Marshal(map[string]string{"body": commentBody})
+	if err != nil {
+		return
+	}
+
+	data, status, err := c.makeRequest(p, "PATCH", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	if status != 200 {
+		var resp Response
+		if e := json.Unmarshal(data, &resp); e == nil && resp.Message != "" {
+			return fmt.Errorf("Comment update failed with status code: %d: %s", status, resp.Message)
 		}
-	}`
+		return fmt.Errorf("Comment update failed with status code: %d", status)
+	}
+	return
+}
+
+// RemoveIssueLabel removes a label from an issue or pull request. A label
+// that isn't on the issue is not an error.
+// Github API docs: https://docs.github.com/en/rest/reference/issues#remove-a-label-from-an-issue
+func (c *Client) RemoveIssueLabel(owner, repo string, number int, label string) (err error) {
+	p := fmt.Sprintf("/repos/%s/%s/issues/%d/labels/%s", owner, repo, number, url.PathEscape(label))
 
-	var jsonStr = []byte(body)
-	url := fmt.Sprintf("%s%s", githubAPIHost, path)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
-	req.Header.Set("Authorization", "token "+GithubToken)
-	req.Header.Set("Content-Type", "application/vnd.github.antiope-preview+json")
+	data, status, err := c.makeRequest(p, "DELETE", nil)
+	if err != nil {
+		return
+	}
+
+	if status != 200 && status != 404 {
+		var resp Response
+		if e := json.Unmarshal(data, &resp); e == nil && resp.Message != "" {
+			return fmt.Errorf("Label removal failed with status code: %d: %s", status, resp.Message)
+		}
+		return fmt.Errorf("Label removal failed with status code: %d", status)
+	}
+	return
+}
+
+// CreateGitCheckRun creates a PR Check
+// Github API docs: https://docs.github.com/en/rest/reference/checks#create-a-check-run
+func (c *Client) CreateGitCheckRun(owner string, repo string, commitSHA string) (checkRunID string, err error) {
+	path := fmt.Sprintf("/repos/%v/%v/check-runs", owner, repo)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	body, err := json.Marshal(CheckRunCreate{
+		Name:       CheckRunName,
+		HeadSHA:    commitSHA,
+		Status:     "in_progress",
+		ExternalID: "03",
+		StartedAt:  time.Now().Format(time.RFC3339),
+		Output: &CheckRunOutput{
+			Title:   "Brakeman Scan",
+			Summary: "Scanning the commits in this pull request for potential security vulnerabilities",
+		},
+	})
 	if err != nil {
-		panic(err)
+		return
 	}
-	defer resp.Body.Close()
 
-	fmt.Println("[CreateGitCheckRun] response Status: ", resp.Status, "repo: ", repo, "owner: ", owner, "commit SHA: ", commitSHA)
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	data, status, err := c.makePostRequest(path, bytes.NewReader(body))
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
-	bodyMap := make(map[string]json.RawMessage)
-	e := json.Unmarshal(bodyBytes, &bodyMap)
-	if e != nil {
-		panic(err)
+
+	c.logf("[CreateGitCheckRun] response Status: %d repo: %s owner: %s commit SHA: %s", status, repo, owner, commitSHA)
+
+	if status != 201 {
+		var resp Response
+		if e := json.Unmarshal(data, &resp); e == nil && resp.Message != "" {
+			return "", fmt.Errorf("Check run creation failed with status code: %d: %s", status, resp.Message)
+		}
+		return "", fmt.Errorf("Check run creation failed with status code: %d", status)
+	}
+
+	var run CheckRun
+	if err = json.Unmarshal(data, &run); err != nil {
+		return "", fmt.Errorf("Couldn't unmarshal response: %s", err)
+	}
+	return fmt.Sprint(run.ID), nil
+}
+
+// CompleteGitCheckRun completes the PR check. The Checks API accepts at most
+// 50 annotations per request, so the annotations are sent in batches and
+// only the last request marks the check run as completed and adds the actions.
+func (c *Client) CompleteGitCheckRun(owner string, repo string, commitSHA string, checkRunID string, scanOutputString string, conclusion string, annotations []CheckRunAnnotation, actions []CheckRunAction) (err error) {
+	output := CheckRunOutput{
+		Title:   "Brakeman Scan Report for the Pull Request",
+		Summary: fmt.Sprintf("%d annotation(s)", len(annotations)),
+		Text:    scanOutputString,
+	}
+
+	for len(annotations) > maxAnnotationsPerRequest {
+		output.Annotations = annotations[:maxAnnotationsPerRequest]
+		annotations = annotations[maxAnnotationsPerRequest:]
+
+		batch := output
+		if err = c.updateGitCheckRun(owner, repo, checkRunID, &CheckRunUpdate{Output: &batch}); err != nil {
+			return
+		}
 	}
-	checkRunID = string(bodyMap["id"])
 
-	return checkRunID
+	output.Annotations = annotations
+	err = c.updateGitCheckRun(owner, repo, checkRunID, &CheckRunUpdate{
+		Name:        CheckRunName,
+		Status:      "completed",
+		Conclusion:  conclusion,
+		CompletedAt: time.Now().Format(time.RFC3339),
+		Output:      &output,
+		Actions:     actions,
+	})
+
+	c.logf("[CompleteGitCheckRun] repo: %s owner: %s commit SHA: %s conclusion: %s", repo, owner, commitSHA, conclusion)
+	return
 }
 
-// CompleteGitCheckRun completes the PR check
-func CompleteGitCheckRun(owner string, repo string, commitSHA string, checkRunID string, scanOutputString string, conclusion string) (err error) {
+// updateGitCheckRun PATCHes an existing check run
+// Github API docs: https://docs.github.com/en/rest/reference/checks#update-a-check-run
+func (c *Client) updateGitCheckRun(owner, repo, checkRunID string, update *CheckRunUpdate) (err error) {
 	path := fmt.Sprintf("/repos/%v/%v/check-runs/%v", owner, repo, checkRunID)
-	body := `{
-		"name": "Brakeman Scan (Security)",
-		"status": "completed",
-		"conclusion": "` + conclusion + `",
-		"completed_at": "` + time.Now().Format(time.RFC3339) + `",
-		"output": {
-		  "title": "Brakeman Scan Report for the Pull Request",
-		  "summary": "",
-		  "text": "` + scanOutputString + `",
-		  "annotations": [
-		
-		  ],
-		  "images": [
-		  ]
-		},
-		"actions": [
-		
-		]
-	  }`
-	var jsonStr = []byte(body)
-	url := fmt.Sprintf("%s%s", githubAPIHost, path)
-	req, err := http.NewRequest("PATCH", url, bytes.NewBuffer(jsonStr))
-	req.Header.Set("Authorization", "token "+GithubToken)
-	req.Header.Set("Content-Type", "application/vnd.github.antiope-preview+json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+
+	body, err := json.Marshal(update)
+	if err != nil {
+		return
+	}
+
+	data, status, err := c.makeRequest(path, "PATCH", bytes.NewReader(body))
 	if err != nil {
-		panic(err)
+		return
 	}
-	defer resp.Body.Close()
-	
-	fmt.Println("[CompleteGitCheckRun] response Status: ", resp.Status, "repo: ", repo, "owner: ", owner, "commit SHA: ", commitSHA)
 
+	if status != 200 {
+		var resp Response
+		if e := json.Unmarshal(data, &resp); e == nil && resp.Message != "" {
+			return fmt.Errorf("Check run update failed with status code: %d: %s", status, resp.Message)
+		}
+		return fmt.Errorf("Check run update failed with status code: %d", status)
+	}
 	return
+}
 
+// UploadSarif uploads a SARIF log to code scanning, so the results show up in
+// the Security tab of the repository. ref is the ref the commit belongs to,
+// e.g. refs/heads/main or refs/pull/1/head
+// Github API docs: https://docs.github.com/en/rest/reference/code-scanning#upload-an-analysis-as-sarif-data
+func (c *Client) UploadSarif(owner, repo, commitSHA, ref string, sarif []byte, startedAt time.Time) (upload *SarifUploadResponse, err error) {
+	path := fmt.Sprintf("/repos/%v/%v/code-scanning/sarifs", owner, repo)
+
+	// the API expects the SARIF file gzip compressed and base64 encoded
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err = zw.Write(sarif); err != nil {
+		return
+	}
+	if err = zw.Close(); err != nil {
+		return
+	}
+
+	body, err := json.Marshal(SarifUpload{
+		CommitSHA: commitSHA,
+		Ref:       ref,
+		Sarif:     base64.StdEncoding.EncodeToString(gz.Bytes()),
+		StartedAt: startedAt.Format(time.RFC3339),
+		ToolName:  "Brakeman",
+	})
+	if err != nil {
+		return
+	}
+
+	data, status, err := c.makePostRequest(path, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	if status != 202 {
+		var resp Response
+		if e := json.Unmarshal(data, &resp); e == nil && resp.Message != "" {
+			return nil, fmt.Errorf("SARIF upload failed with status code: %d: %s", status, resp.Message)
+		}
+		return nil, fmt.Errorf("SARIF upload failed with status code: %d", status)
+	}
+
+	if err = json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("Couldn't unmarshal response: %s", err)
+	}
+	return
 }
 
-func CloneGitRepository(repoURL string, dir string) (err error) {
-	fmt.Println("[CloneGitRepository] repo= ", repoURL)
-	_, err = git.PlainClone(dir, false, &git.CloneOptions{
-		URL:      repoURL,
+// CloneGitRepository fetches a single ref of a repository into dir and checks
+// out the given commit, which is verified to be the commit in the work tree.
+// ref is a full ref like refs/pull/1/head, or a branch name. Only the tip of
+// the ref is fetched, the history is only fetched when the ref moved on since
+// the commit. An empty sha checks out the tip of the ref. Returns the commit
+// that was checked out. The clone is stopped when ctx is cancelled.
+func (c *Client) CloneGitRepository(ctx context.Context, repoURL, ref, sha, dir string) (checkedOut string, err error) {
+	c.logf("[CloneGitRepository] repo= %s ref= %s sha= %s", repoURL, ref, sha)
+	if !strings.HasPrefix(ref, "refs/") {
+		ref = plumbing.NewBranchReferenceName(ref).String()
+	}
+
+	r, err := c.fetchRef(ctx, repoURL, ref, dir, 1)
+	if err != nil {
+		return "", err
+	}
+
+	hash := plumbing.NewHash(sha)
+	if sha == "" {
+		tip, err := r.Reference(fetchedRef, true)
+		if err != nil {
+			return "", err
+		}
+		hash = tip.Hash()
+	} else if _, err = r.CommitObject(hash); err != nil {
+		// the ref moved on since the commit, so the commit is further down its history
+		c.logf("[CloneGitRepository] %s is not the tip of %s, fetching its history", sha, ref)
+		if err = os.RemoveAll(filepath.Join(dir, git.GitDirName)); err != nil {
+			return "", err
+		}
+		if r, err = c.fetchRef(ctx, repoURL, ref, dir, 0); err != nil {
+			return "", err
+		}
+		if _, err = r.CommitObject(hash); err != nil {
+			return "", fmt.Errorf("Commit %s is not on %s of %s", sha, ref, repoURL)
+		}
+	}
+
+	wt, err := r.Worktree()
+	if err != nil {
+		return "", err
+	}
+	if err = wt.Checkout(&git.CheckoutOptions{Hash: hash, Force: true}); err != nil {
+		return "", fmt.Errorf("Couldn't check out %s: %s", hash, err)
+	}
+
+	// make sure the code that gets scanned is the code of the commit
+	head, err := r.Head()
+	if err != nil {
+		return "", err
+	}
+	if head.Hash() != hash {
+		return "", fmt.Errorf("Checked out %s instead of %s", head.Hash(), hash)
+	}
+	c.logf("[CloneGitRepository] Successful, checked out %s", head.Hash())
+	return head.Hash().String(), nil
+}
+
+// fetchedRef is the local ref CloneGitRepository fetches into
+const fetchedRef = plumbing.ReferenceName("refs/remotes/origin/scan")
+
+// fetchRef creates a repository in dir and fetches a single ref into it, with
+// depth commits of its history, 0 for all of it
+func (c *Client) fetchRef(ctx context.Context, repoURL, ref, dir string, depth int) (*git.Repository, error) {
+	token, err := c.Auth.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := r.CreateRemote(&gitConfig.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{c.cloneURL(repoURL)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = remote.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: []gitConfig.RefSpec{gitConfig.RefSpec(fmt.Sprintf("+%s:%s", ref, fetchedRef))},
+		Depth:    depth,
+		Tags:     git.NoTags,
 		Progress: os.Stdout,
 		Auth: &gitHttp.BasicAuth{
 			Username: "abc123", // anything except an empty string (yes, it can be any string :D)
-			Password: GithubToken,
+			Password: token,
 		},
 	})
-
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("Couldn't fetch %s of %s: %s", ref, repoURL, err)
 	}
-	fmt.Println("[CloneGitRepository] Successful")
-	return
-
+	return r, nil
 }
@@ -15,6 +15,13 @@ type TokenResponse struct {
 	ExpiresAt string `json:"expires_at,omitempty"`
 }
 
+// App represents a GitHub App
+type App struct {
+	ID   *int64  `json:"id,omitempty"`
+	Slug *string `json:"slug,omitempty"`
+	Name *string `json:"name,omitempty"`
+}
+
 // IssueCreate represents the struct to POST a new issue
 type IssueCreate struct {
 	Title     string   `json:"title"`
@@ -173,3 +180,166 @@ type PullRequestFile struct {
 type PullRequestFileResponse struct {
 	Files []PullRequestFile
 }
+
+// CheckRunOutput represents the output shown on a check run
+type CheckRunOutput struct {
+	Title       string               `json:"title"`
+	Summary     string               `json:"summary"`
+	Text        string               `json:"text,omitempty"`
+	Annotations []CheckRunAnnotation `json:"annotations,omitempty"`
+}
+
+// CheckRunAnnotation represents a finding attached to a line of a file
+// https://docs.github.com/en/rest/reference/checks#annotations-object
+type CheckRunAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Title           string `json:"title,omitempty"`
+	Message         string `json:"message"`
+	RawDetails      string `json:"raw_details,omitempty"`
+}
+
+// CheckRunCreate represents the struct to POST a new check run
+type CheckRunCreate struct {
+	Name       string          `json:"name"`
+	HeadSHA    string          `json:"head_sha"`
+	Status     string          `json:"status,omitempty"`
+	ExternalID string          `json:"external_id,omitempty"`
+	StartedAt  string          `json:"started_at,omitempty"`
+	Output     *CheckRunOutput `json:"output,omitempty"`
+}
+
+// CheckRun represents a check run
+type CheckRun struct {
+	ID         int64  `json:"id"`
+	HeadSHA    string `json:"head_sha,omitempty"`
+	Status     string `json:"status,omitempty"`
+	Conclusion string `json:"conclusion,omitempty"`
+	HTMLURL    string `json:"html_url,omitempty"`
+}
+
+// CheckRunUpdate represents the struct to PATCH an existing check run
+type CheckRunUpdate struct {
+	Name        string           `json:"name,omitempty"`
+	Status      string           `json:"status,omitempty"`
+	Conclusion  string           `json:"conclusion,omitempty"`
+	CompletedAt string           `json:"completed_at,omitempty"`
+	Output      *CheckRunOutput  `json:"output,omitempty"`
+	Actions     []CheckRunAction `json:"actions,omitempty"`
+}
+
+// CheckRunAction represents a button shown on a check run, clicking it sends a
+// check_run requested_action event with the identifier
+// https://docs.github.com/en/rest/reference/checks#check-runs-and-requested-actions
+type CheckRunAction struct {
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Identifier  string `json:"identifier"`
+}
+
+// SarifUpload represents the struct to POST a SARIF file to code scanning
+type SarifUpload struct {
+	CommitSHA string `json:"commit_sha"`
+	Ref       string `json:"ref"`
+	Sarif     string `json:"sarif"`
+	StartedAt string `json:"started_at,omitempty"`
+	ToolName  string `json:"tool_name,omitempty"`
+}
+
+// SarifUploadResponse represents the response to a SARIF upload
+type SarifUploadResponse struct {
+	ID  string `json:"id,omitempty"`
+	URL string `json:"url,omitempty"`
+}
+
+// Branch represents a branch of a repository
+type Branch struct {
+	Name      *string     `json:"name,omitempty"`
+	Commit    *RepoCommit `json:"commit,omitempty"`
+	Protected *bool       `json:"protected,omitempty"`
+}
+
+// User represents a GitHub user or organization
+type User struct {
+	Login *string `json:"login,omitempty"`
+	ID    *int64  `json:"id,omitempty"`
+	Type  *string `json:"type,omitempty"`
+}
+
+// Repository represents a GitHub repository
+type Repository struct {
+	ID            *int64  `json:"id,omitempty"`
+	Name          *string `json:"name,omitempty"`
+	FullName      *string `json:"full_name,omitempty"`
+	Owner         *User   `json:"owner,omitempty"`
+	HTMLURL       *string `json:"html_url,omitempty"`
+	CloneURL      *string `json:"clone_url,omitempty"`
+	DefaultBranch *string `json:"default_branch,omitempty"`
+	Fork          *bool   `json:"fork,omitempty"`
+}
+
+// PullRequestBranch represents the head or base of a pull request
+type PullRequestBranch struct {
+	Label *string     `json:"label,omitempty"`
+	Ref   *string     `json:"ref,omitempty"`
+	SHA   *string     `json:"sha,omitempty"`
+	Repo  *Repository `json:"repo,omitempty"`
+	User  *User       `json:"user,omitempty"`
+}
+
+// PullRequest represents a pull request
+type PullRequest struct {
+	ID             *int64             `json:"id,omitempty"`
+	Number         *int               `json:"number,omitempty"`
+	State          *string            `json:"state,omitempty"`
+	Title          *string            `json:"title,omitempty"`
+	User           *User              `json:"user,omitempty"`
+	Draft          *bool              `json:"draft,omitempty"`
+	Mergeable      *bool              `json:"mergeable,omitempty"`
+	MergeCommitSHA *string            `json:"merge_commit_sha,omitempty"`
+	HTMLURL        *string            `json:"html_url,omitempty"`
+	Head           *PullRequestBranch `json:"head,omitempty"`
+	Base           *PullRequestBranch `json:"base,omitempty"`
+	Labels         []*Label           `json:"labels,omitempty"`
+}
+
+// Label represents a label of an issue or pull request
+type Label struct {
+	ID   *int64  `json:"id,omitempty"`
+	Name *string `json:"name,omitempty"`
+}
+
+// CollaboratorPermission represents the permission a user has on a repository
+type CollaboratorPermission struct {
+	// Permission is one of admin, write, read or none
+	Permission *string `json:"permission,omitempty"`
+	User       *User   `json:"user,omitempty"`
+}
+
+// ContentUpdate represents the struct to PUT a file to a repository
+type ContentUpdate struct {
+	Message string `json:"message"`
+	// Content is the base64 encoded content of the file
+	Content string `json:"content"`
+	// SHA is the blob SHA of the file being replaced, empty for new files
+	SHA    string `json:"sha,omitempty"`
+	Branch string `json:"branch,omitempty"`
+}
+
+// ContentUpdateResponse represents the response to a file update
+type ContentUpdateResponse struct {
+	Content *Content `json:"content,omitempty"`
+	Commit  *Commit  `json:"commit,omitempty"`
+}
+
+// IssueComment represents a comment on an issue or pull request
+type IssueComment struct {
+	ID        *int64     `json:"id,omitempty"`
+	Body      *string    `json:"body,omitempty"`
+	User      *User      `json:"user,omitempty"`
+	HTMLURL   *string    `json:"html_url,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package github
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// DefaultBaseURL is the address of the GitHub API
+const DefaultBaseURL = "https://api.github.com"
+
+// DefaultWebURL is the address of the GitHub web interface
+const DefaultWebURL = "https://github.com"
+
+// enterpriseAPIPath is where GitHub Enterprise Server serves the API
+const enterpriseAPIPath = "/api/v3"
+
+// DefaultTimeout is how long a request to the API may take, including reading the response
+const DefaultTimeout = 60 * time.Second
+
+// defaultAccept is sent with requests that don't ask for a particular media type
+const defaultAccept = "application/vnd.github.symmetra-preview+json"
+
+// TokenSource returns the token requests are authenticated with
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// StaticToken is a token that doesn't change, e.g. a personal access token
+type StaticToken string
+
+// Token returns the token
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// Logger is where a Client logs its requests, a *log.Logger will do
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Client calls the GitHub API. A Client only holds its configuration, so it
+// can be copied to act with another token.
+type Client struct {
+	// Name identifies the client in the rate limit stats
+	Name string
+	// BaseURL is the address of the API, without a trailing slash
+	BaseURL string
+	// WebURL is the address of the web interface, used to link to code
+	WebURL string
+	// CloneURL replaces the WebURL in the URLs repositories are cloned from,
+	// empty to clone from the web address
+	CloneURL string
+	// Auth gives the token sent in the Authorization header
+	Auth       TokenSource
+	HTTPClient *http.Client
+	UserAgent  string
+	Logger     Logger
+}
+
+// NewClient returns a client for api.github.com authenticating with the
+// given token source. Its requests go through a RateLimitTransport.
+func NewClient(auth TokenSource) *Client {
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		WebURL:     DefaultWebURL,
+		Auth:       auth,
+		HTTPClient: &http.Client{Transport: NewRateLimitTransport(nil)},
+		UserAgent:  "ci-brakeman",
+		Logger:     log.New(os.Stdout, "", log.LstdFlags),
+	}
+}
+
+// SetEnterpriseURLs points the client at a GitHub Enterprise Server. apiURL
+// is the address of the API, e.g. https://ghe.example.com/api/v3. An empty
+// webURL is derived from the apiURL, cloneURL is optional.
+func (c *Client) SetEnterpriseURLs(apiURL, webURL, cloneURL string) error {
+	api, err := url.Parse(apiURL)
+	if err != nil || api.Scheme == "" || api.Host == "" {
+		return fmt.Errorf("invalid GitHub API URL %q", apiURL)
+	}
+	c.BaseURL = strings.TrimSuffix(apiURL, "/")
+
+	if webURL == "" {
+		web := *api
+		web.Path = strings.TrimSuffix(strings.TrimSuffix(web.Path, "/"), enterpriseAPIPath)
+		webURL = web.String()
+	}
+	c.WebURL = strings.TrimSuffix(webURL, "/")
+	c.CloneURL = strings.TrimSuffix(cloneURL, "/")
+	return nil
+}
+
+// RepoURL returns the address of a repository in the web interface
+func (c *Client) RepoURL(owner, repo string) string {
+	return fmt.Sprintf("%s/%s/%s", c.WebURL, owner, repo)
+}
+
+// cloneURL returns the URL to clone a repository from, given its web address
+func (c *Client) cloneURL(repoURL string) string {
+	if c.CloneURL == "" || !strings.HasPrefix(repoURL, c.WebURL+"/") {
+		return repoURL
+	}
+	return c.CloneURL + strings.TrimPrefix(repoURL, c.WebURL)
+}
+
+// WithAuth returns a copy of the client with another name authenticating with
+// another token source
+func (c *Client) WithAuth(name string, auth TokenSource) *Client {
+	cp := *c
+	cp.Name = name
+	cp.Auth = auth
+	return &cp
+}
+
+// RateLimitStats returns the rate limits seen by the transport of the client,
+// false if the client doesn't use a RateLimitTransport
+func (c *Client) RateLimitStats() (RateLimitStats, bool) {
+	if c.HTTPClient != nil {
+		if t, ok := c.HTTPClient.Transport.(*RateLimitTransport); ok {
+			return t.Stats(), true
+		}
+	}
+	return RateLimitStats{}, false
+}
+
+// logf writes to the logger of the client, if it has one
+func (c *Client) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
+
+func (c *Client) makeGetRequest(path string) (resp []byte, statusCode int, err error) {
+	return c.makeRequest(path, "GET", nil)
+}
+
+func (c *Client) makePostRequest(path string, data io.Reader) (resp []byte, statusCode int, err error) {
+	return c.makeRequest(path, "POST", data)
+}
+
+// makeRequest calls the API with the default media type
+func (c *Client) makeRequest(path, method string, data io.Reader) (resp []byte, statusCode int, err error) {
+	return c.request(path, method, defaultAccept, data)
+}
+
+// request calls the API and returns the body and status code of the response.
+// path is relative to the BaseURL.
+func (c *Client) request(path, method, accept string, data io.Reader) (resp []byte, statusCode int, err error) {
+	resp, _, statusCode, err = c.do(path, method, accept, data)
+	return
+}
+
+// do calls the API and returns the body, headers and status code of the response
+func (c *Client) do(path, method, accept string, data io.Reader) (resp []byte, header http.Header, statusCode int, err error) {
+	ctx := withClientName(context.Background(), c.Name)
+	request, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, data)
+	if err != nil {
+		return
+	}
+
+	token, err := c.Auth.Token()
+	if err != nil {
+		return
+	}
+	request.Header.Set("Accept", accept)
+	// Bearer works for the JWT of the GitHub App as well as for access tokens
+	request.Header.Set("Authorization", "Bearer "+token)
+	if data != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.UserAgent != "" {
+		request.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	resp, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+	// return status code so that api function knows what the outcome was
+	statusCode = response.StatusCode
+	header = response.Header
+	return
+}
+
+// AppTokenSource authenticates as the GitHub App, with JWTs signed by its
+// private key. A JWT is valid for 10 minutes, a new one is signed shortly
+// before it expires.
+type AppTokenSource struct {
+	appID string
+	key   *rsa.PrivateKey
+
+	mu        sync.Mutex
+	jwt       string
+	expiresAt time.Time
+}
+
+// NewAppTokenSource returns a token source for the GitHub App with the given
+// ID and PEM encoded private key
+func NewAppTokenSource(appID string, pemKey []byte) (*AppTokenSource, error) {
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(pemKey)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse the private key of the GitHub App: %s", err)
+	}
+	return &AppTokenSource{appID: appID, key: key}, nil
+}
+
+// Token returns a JWT for the GitHub App
+func (a *AppTokenSource) Token() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if a.jwt != "" && now.Add(time.Minute).Before(a.expiresAt) {
+		return a.jwt, nil
+	}
+
+	// GitHub accepts JWTs that expire at most 10 minutes in the future
+	expiresAt := now.Add(9 * time.Minute)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		// issued a minute ago, in case the clock of GitHub is behind ours
+		"iat": now.Add(-time.Minute).Unix(),
+		"iss": a.appID,
+		"exp": expiresAt.Unix(),
+	})
+	signed, err := token.SignedString(a.key)
+	if err != nil {
+		return "", err
+	}
+	a.jwt, a.expiresAt = signed, expiresAt
+	return signed, nil
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+	"strings"
+)
+
+// perPage is the number of items requested per page, the most the API returns
+const perPage = 100
+
+// paginate yields the items of all pages of a list endpoint, following the
+// next links in the Link header. Iteration stops at the first error, which is
+// yielded along with a zero item.
+// Github API docs: https://docs.github.com/en/rest/overview/resources-in-the-rest-api#pagination
+func paginate[T any](c *Client, path string) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		next := path
+		if !strings.Contains(next, "per_page=") {
+			sep := "?"
+			if strings.Contains(next, "?") {
+				sep = "&"
+			}
+			next = fmt.Sprintf("%s%sper_page=%d", next, sep, perPage)
+		}
+
+		for next != "" {
+			data, header, status, err := c.do(next, "GET", defaultAccept, nil)
+			if err == nil && status != 200 {
+				err = fmt.Errorf("Fetch failed with status code: %d", status)
+				var resp Response
+				if e := json.Unmarshal(data, &resp); e == nil && resp.Message != "" {
+					err = fmt.Errorf("Fetch failed with status code: %d: %s", status, resp.Message)
+				}
+			}
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			var items []T
+			if err = json.Unmarshal(data, &items); err != nil {
+				yield(zero, fmt.Errorf("Couldn't unmarshal response: %s", err))
+				return
+			}
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			if next, err = c.nextPage(header); err != nil {
+				yield(zero, err)
+				return
+			}
+		}
+	}
+}
+
+// collect returns all items of a paginated list
+func collect[T any](seq iter.Seq2[T, error]) (items []T, err error) {
+	for item, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// nextPage returns the path of the next page from the Link header of a
+// response, or an empty string on the last page
+func (c *Client) nextPage(header http.Header) (string, error) {
+	// Link: <https://api.github.com/repositories/1/pulls/1/files?page=2>; rel="next", <...>; rel="last"
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		isNext := false
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				isNext = true
+			}
+		}
+		if !isNext {
+			continue
+		}
+
+		url := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+		// the token must not be sent anywhere else than the API
+		if !strings.HasPrefix(url, c.BaseURL+"/") {
+			return "", fmt.Errorf("next page %s is not on %s", url, c.BaseURL)
+		}
+		return strings.TrimPrefix(url, c.BaseURL), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package github
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNextPage(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		link    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "no link header",
+		},
+		{
+			name: "next and last",
+			link: `<https://api.github.com/repositories/1/pulls/1/files?per_page=100&page=2>; rel="next", <https://api.github.com/repositories/1/pulls/1/files?per_page=100&page=5>; rel="last"`,
+			want: "/repositories/1/pulls/1/files?per_page=100&page=2",
+		},
+		{
+			name: "last page",
+			link: `<https://api.github.com/repositories/1/pulls/1/files?page=4>; rel="prev", <https://api.github.com/repositories/1/pulls/1/files?page=1>; rel="first"`,
+		},
+		{
+			name:    "enterprise server",
+			baseURL: "https://github.example.com/api/v3",
+			link:    `<https://github.example.com/api/v3/repositories/1/issues/1/comments?page=2>; rel="next"`,
+			want:    "/repositories/1/issues/1/comments?page=2",
+		},
+		{
+			name:    "next page on another host",
+			link:    `<https://attacker.example.com/repositories/1/pulls/1/files?page=2>; rel="next"`,
+			wantErr: true,
+		},
+		{
+			name:    "next page on a host sharing the prefix",
+			link:    `<https://api.github.com.example.com/repositories?page=2>; rel="next"`,
+			wantErr: true,
+		},
+		{
+			name: "malformed link",
+			link: `<https://api.github.com/repositories?page=2>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient(nil)
+			if tt.baseURL != "" {
+				c.BaseURL = tt.baseURL
+			}
+			header := http.Header{}
+			if tt.link != "" {
+				header.Set("Link", tt.link)
+			}
+
+			got, err := c.nextPage(header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nextPage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("nextPage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
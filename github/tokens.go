@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package github
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// tokenRefreshMargin is how long before it expires a token gets replaced,
+// so a token never runs out in the middle of a scan
+const tokenRefreshMargin = 10 * time.Minute
+
+// cachedToken is an installation access token along with its expiry. Its lock
+// is held while a new token is requested, so concurrent jobs for the same
+// installation don't all request one at the same time.
+type cachedToken struct {
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// Installations hands out clients acting on behalf of the installations of a
+// GitHub App. The access tokens of the installations are cached and only
+// requested again shortly before they expire.
+type Installations struct {
+	app *Client
+
+	// mu only guards the map, never a request
+	mu     sync.Mutex
+	tokens map[string]*cachedToken
+}
+
+// NewInstallations returns the installations of the GitHub App the given
+// client authenticates as
+func NewInstallations(app *Client) *Installations {
+	return &Installations{app: app, tokens: make(map[string]*cachedToken)}
+}
+
+// Client returns a client acting on behalf of the given installation, with the
+// configuration of the client of the GitHub App
+func (i *Installations) Client(installationID string) *Client {
+	return i.app.WithAuth("installation "+installationID, installationTokenSource{installations: i, id: installationID})
+}
+
+// RateLimitStats returns the rate limits of the GitHub App and its installations
+func (i *Installations) RateLimitStats() (RateLimitStats, bool) {
+	return i.app.RateLimitStats()
+}
+
+// installationTokenSource gives the cached access token of an installation
+type installationTokenSource struct {
+	installations *Installations
+	id            string
+}
+
+func (s installationTokenSource) Token() (string, error) {
+	return s.installations.Token(s.id)
+}
+
+// Token returns an access token for the given installation of the GitHub App
+func (i *Installations) Token(installationID string) (string, error) {
+	if installationID == "" {
+		return "", fmt.Errorf("no installation ID given")
+	}
+
+	// an installation that is slow or rate limited only holds up its own jobs
+	i.mu.Lock()
+	t, ok := i.tokens[installationID]
+	if !ok {
+		t = &cachedToken{}
+		i.tokens[installationID] = t
+	}
+	i.mu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && time.Now().Add(tokenRefreshMargin).Before(t.expiresAt) {
+		return t.token, nil
+	}
+
+	var resp *TokenResponse
+	var err error
+	// try at least 3 times to get a token
+	for n := 0; n < 3; n++ {
+		if resp, err = i.app.GetAccessToken(installationID); err == nil {
+			break
+		}
+		err = fmt.Errorf("Auth attempt: %d for installation %s Err: %s", n, installationID, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, resp.ExpiresAt)
+	if err != nil {
+		// installation tokens are valid for an hour
+		expiresAt = time.Now().Add(time.Hour)
+	}
+	t.token, t.expiresAt = resp.Token, expiresAt
+	return resp.Token, nil
+}
@@ -0,0 +1,333 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a request would have to wait longer than
+// allowed for a rate limit to reset
+var ErrRateLimited = errors.New("GitHub API rate limit exceeded")
+
+// secondaryLimitWait is how long to wait after hitting a secondary rate limit
+// when GitHub doesn't say how long
+const secondaryLimitWait = time.Minute
+
+// maxBackoff is the longest wait between retries of failed requests
+const maxBackoff = 30 * time.Second
+
+// RateLimit is the state of a rate limit as last reported by GitHub
+type RateLimit struct {
+	// Client is the name of the client the limit applies to, see Client.Name
+	Client    string    `json:"client"`
+	Resource  string    `json:"resource"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// RateLimitStats reports the rate limits and how the transport dealt with them
+type RateLimitStats struct {
+	// Retries of requests that failed or hit a rate limit
+	Retries int64 `json:"retries"`
+	// Waits is the number of times a request waited for a rate limit to reset
+	Waits int64 `json:"waits"`
+	// Limited is the number of responses that said a rate limit was hit
+	Limited int64       `json:"limited"`
+	Limits  []RateLimit `json:"limits"`
+}
+
+// clientNameKey is the context key of the client name
+type clientNameKey struct{}
+
+// withClientName tells the transport which client a request comes from, so the
+// rate limits of the installations are tracked apart
+func withClientName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, clientNameKey{}, name)
+}
+
+// RateLimitTransport is a http.RoundTripper that keeps track of the rate limits
+// of every client. Requests wait while the rate limit of their client is used
+// up or a secondary rate limit was hit, and are sent again once it resets.
+// Idempotent requests are also retried on network errors and 5xx responses.
+type RateLimitTransport struct {
+	// Base sends the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+	// Timeout of a single attempt, including reading the response body. 0 for none.
+	Timeout time.Duration
+	// MaxRetries is how often a request is sent again
+	MaxRetries int
+	// MaxWait is the longest a request waits for a rate limit to reset,
+	// requests that would have to wait longer fail with ErrRateLimited
+	MaxWait time.Duration
+
+	mu sync.Mutex
+	// limits are keyed by client and resource
+	limits map[string]*RateLimit
+	// blocked holds until when the requests of a client have to wait
+	blocked                 map[string]time.Time
+	retries, waits, limited int64
+}
+
+// NewRateLimitTransport returns a transport sending the requests with base
+func NewRateLimitTransport(base http.RoundTripper) *RateLimitTransport {
+	return &RateLimitTransport{
+		Base:       base,
+		Timeout:    DefaultTimeout,
+		MaxRetries: 3,
+		MaxWait:    15 * time.Minute,
+		limits:     make(map[string]*RateLimit),
+		blocked:    make(map[string]time.Time),
+	}
+}
+
+// RoundTrip sends the request, waiting and retrying as needed
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	client, _ := req.Context().Value(clientNameKey{}).(string)
+	// a request whose body can't be read again is only sent once
+	replayable := req.Body == nil || req.GetBody != nil
+
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req.Context(), client); err != nil {
+			return nil, err
+		}
+
+		resp, err := t.send(req, attempt)
+		retry := replayable && attempt < t.MaxRetries && req.Context().Err() == nil
+		if err != nil {
+			if retry && idempotent(req.Method) {
+				t.count(&t.retries)
+				if err = sleep(req.Context(), backoff(attempt)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, err
+		}
+
+		t.update(client, resp)
+
+		if wait, limited := rateLimited(resp); limited {
+			t.count(&t.limited)
+			until := time.Now().Add(wait)
+			t.block(client, until)
+			if retry && wait <= t.MaxWait {
+				// requests that hit a rate limit weren't processed, so any request can be sent again
+				t.count(&t.retries)
+				discard(resp)
+				continue
+			}
+			return resp, nil
+		}
+
+		if resp.StatusCode >= 500 && retry && idempotent(req.Method) {
+			t.count(&t.retries)
+			discard(resp)
+			if err = sleep(req.Context(), backoff(attempt)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// send makes a single attempt, the attempt times out after Timeout
+func (t *RateLimitTransport) send(req *http.Request, attempt int) (*http.Response, error) {
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if t.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+	}
+
+	r := req.Clone(ctx)
+	if attempt > 0 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(r)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// the timeout covers reading the body, so it is only released when the body is closed
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// wait blocks while the requests of the client have to wait for a rate limit
+func (t *RateLimitTransport) wait(ctx context.Context, client string) error {
+	t.mu.Lock()
+	until := t.blocked[client]
+	t.mu.Unlock()
+
+	d := time.Until(until)
+	if d <= 0 {
+		return nil
+	}
+	if d > t.MaxWait {
+		return fmt.Errorf("%w, resets at %s", ErrRateLimited, until.Format(time.RFC3339))
+	}
+	t.count(&t.waits)
+	return sleep(ctx, d)
+}
+
+// block makes the requests of a client wait until the given time
+func (t *RateLimitTransport) block(client string, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until.After(t.blocked[client]) {
+		t.blocked[client] = until
+	}
+}
+
+// update records the rate limit reported with a response. When the limit is
+// used up the client waits for the reset. Limits are tracked per resource, but
+// a used up limit blocks all requests of the client, most requests count
+// against the core limit anyway.
+func (t *RateLimitTransport) update(client string, resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	reset, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	resource := resp.Header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = "core"
+	}
+
+	rl := &RateLimit{Client: client, Resource: resource, Limit: limit, Remaining: remaining, Reset: time.Unix(reset, 0)}
+	t.mu.Lock()
+	t.limits[client+"/"+resource] = rl
+	t.mu.Unlock()
+
+	if remaining == 0 && reset > 0 {
+		t.block(client, rl.Reset)
+	}
+}
+
+func (t *RateLimitTransport) count(n *int64) {
+	t.mu.Lock()
+	*n++
+	t.mu.Unlock()
+}
+
+// Stats returns the rate limits last reported by GitHub and counters of
+// the retries and waits
+func (t *RateLimitTransport) Stats() RateLimitStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := RateLimitStats{Retries: t.retries, Waits: t.waits, Limited: t.limited}
+	for _, rl := range t.limits {
+		stats.Limits = append(stats.Limits, *rl)
+	}
+	sort.Slice(stats.Limits, func(i, j int) bool {
+		if stats.Limits[i].Client != stats.Limits[j].Client {
+			return stats.Limits[i].Client < stats.Limits[j].Client
+		}
+		return stats.Limits[i].Resource < stats.Limits[j].Resource
+	})
+	return stats
+}
+
+// rateLimited reports whether a response says a rate limit was hit and how
+// long to wait before sending the request again
+// https://docs.github.com/en/rest/overview/resources-in-the-rest-api#rate-limiting
+func rateLimited(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != 403 && resp.StatusCode != 429 {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return time.Duration(s) * time.Second, true
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			wait := time.Until(time.Unix(reset, 0))
+			if wait < 0 {
+				wait = 0
+			}
+			return wait, true
+		}
+		return secondaryLimitWait, true
+	}
+	// a 403 without rate limit headers is a permission problem
+	if resp.StatusCode == 429 {
+		return secondaryLimitWait, true
+	}
+	return 0, false
+}
+
+// idempotent reports whether a request can be sent again without changing the outcome
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// backoff returns the wait before the next attempt, growing exponentially with some jitter
+func backoff(attempt int) time.Duration {
+	d := time.Second << uint(attempt)
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// discard reads and closes the body of a response that won't be used, so the
+// connection can be reused
+func discard(resp *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
+
+// cancelBody releases the context of an attempt when the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
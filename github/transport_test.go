@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package github
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// server answers the requests with the given handlers in turn, the last one
+// answers all remaining requests. It returns a counter of the requests.
+func server(t *testing.T, handlers ...http.HandlerFunc) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		if n > len(handlers) {
+			n = len(handlers)
+		}
+		handlers[n-1](w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+// status answers with the status code and headers given as name, value pairs
+func status(code int, headers ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.WriteHeader(code)
+	}
+}
+
+// send makes a request through a transport, closing the body of the response
+func send(t *testing.T, tr *RateLimitTransport, method, url string) (*http.Response, error) {
+	t.Helper()
+	var body io.Reader
+	if method == "POST" {
+		body = strings.NewReader(`{"name":"brakeman"}`)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(withClientName(req.Context(), "acme"))
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestRetryAfter(t *testing.T) {
+	srv, calls := server(t,
+		status(403, "Retry-After", "1"),
+		status(200),
+	)
+	tr := NewRateLimitTransport(nil)
+
+	start := time.Now()
+	resp, err := send(t, tr, "POST", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || atomic.LoadInt32(calls) != 2 {
+		t.Errorf("got %d after %d calls, want 200 after 2", resp.StatusCode, *calls)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("retried after %s, want to wait for Retry-After", elapsed)
+	}
+	if s := tr.Stats(); s.Limited != 1 || s.Retries != 1 || s.Waits != 1 {
+		t.Errorf("Stats() = %+v, want 1 limited, 1 retry and 1 wait", s)
+	}
+}
+
+func TestRateLimitReset(t *testing.T) {
+	reset := time.Now().Add(time.Second).Truncate(time.Second).Add(time.Second)
+	srv, calls := server(t,
+		status(403, "X-RateLimit-Limit", "5000", "X-RateLimit-Remaining", "0", "X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10)),
+		status(200, "X-RateLimit-Limit", "5000", "X-RateLimit-Remaining", "4999", "X-RateLimit-Reset", strconv.FormatInt(reset.Add(time.Hour).Unix(), 10)),
+	)
+	tr := NewRateLimitTransport(nil)
+
+	resp, err := send(t, tr, "GET", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || atomic.LoadInt32(calls) != 2 {
+		t.Errorf("got %d after %d calls, want 200 after 2", resp.StatusCode, *calls)
+	}
+	if now := time.Now(); now.Before(reset) {
+		t.Errorf("retried at %s, want to wait for the reset at %s", now, reset)
+	}
+
+	s := tr.Stats()
+	if len(s.Limits) != 1 {
+		t.Fatalf("Stats().Limits = %+v, want the core limit", s.Limits)
+	}
+	if rl := s.Limits[0]; rl.Client != "acme" || rl.Resource != "core" || rl.Limit != 5000 || rl.Remaining != 4999 {
+		t.Errorf("rate limit = %+v, want the last one reported for acme", rl)
+	}
+}
+
+func TestRetryServerErrors(t *testing.T) {
+	tests := []struct {
+		method string
+		calls  int32
+		code   int
+	}{
+		// reads are sent again
+		{"GET", 2, 200},
+		// a POST may have been processed, it is not
+		{"POST", 1, 502},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			srv, calls := server(t, status(502), status(200))
+			tr := NewRateLimitTransport(nil)
+
+			resp, err := send(t, tr, tt.method, srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.code || atomic.LoadInt32(calls) != tt.calls {
+				t.Errorf("got %d after %d calls, want %d after %d", resp.StatusCode, *calls, tt.code, tt.calls)
+			}
+		})
+	}
+}
+
+func TestMaxWait(t *testing.T) {
+	srv, calls := server(t,
+		status(429, "Retry-After", "3600"),
+		status(200),
+	)
+	tr := NewRateLimitTransport(nil)
+	tr.MaxWait = time.Minute
+
+	// the limit resets too late to wait for it, the response is passed on
+	resp, err := send(t, tr, "GET", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 429 || atomic.LoadInt32(calls) != 1 {
+		t.Errorf("got %d after %d calls, want 429 after 1", resp.StatusCode, *calls)
+	}
+
+	// later requests of the client fail without being sent
+	start := time.Now()
+	if _, err := send(t, tr, "GET", srv.URL); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("error = %v, want ErrRateLimited", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("failed after %s, want to fail right away", elapsed)
+	}
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("server got %d calls, want 1", n)
+	}
+}
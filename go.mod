@@ -1,12 +1,13 @@
 module github.com/ci-brakeman
 
-go 1.17
+go 1.23
 
 require (
 	github.com/dgrijalva/jwt-go v3.2.1-0.20180921172315-3af4c746e1c2+incompatible
 	github.com/joho/godotenv v1.2.1-0.20180115024921-6bb08516677f
 	github.com/tidwall/gjson v1.10.1
 	gopkg.in/src-d/go-git.v4 v4.8.1
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -47,5 +48,4 @@ require (
 	golang.org/x/text v0.3.1-0.20180302201248-b7ef84aaf62a // indirect
 	gopkg.in/src-d/go-billy.v4 v4.3.0 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
-	gopkg.in/yaml.v2 v2.0.0 // indirect
 )
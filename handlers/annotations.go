@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - annotations
+// Contains the logic to turn brakeman warnings into check run annotations
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ci-brakeman/github"
+	"github.com/ci-brakeman/scanner"
+)
+
+// annotationLevel maps the brakeman confidence of a warning to the level
+// of its annotation
+func annotationLevel(confidence string) string {
+	switch confidence {
+	case "High":
+		return "failure"
+	case "Medium":
+		return "warning"
+	default:
+		return "notice"
+	}
+}
+
+// annotationsFromWarnings creates an annotation for every warning, so the
+// warnings show up inline in the "Files changed" view of the pull request
+func annotationsFromWarnings(warnings []scanner.WarningInfo) []github.CheckRunAnnotation {
+	annotations := make([]github.CheckRunAnnotation, 0, len(warnings))
+	for _, w := range warnings {
+		// warnings that aren't tied to a line are attached to the top of the file
+		line := w.Line
+		if line < 1 {
+			line = 1
+		}
+
+		var details []string
+		if w.Code != "" {
+			details = append(details, fmt.Sprintf("Code: %s", w.Code))
+		}
+		if w.UserInput != "" {
+			details = append(details, fmt.Sprintf("User input: %s", w.UserInput))
+		}
+		details = append(details, fmt.Sprintf("Confidence: %s", w.Confidence))
+		if w.Link != "" {
+			details = append(details, fmt.Sprintf("More information: %s", w.Link))
+		}
+
+		annotations = append(annotations, github.CheckRunAnnotation{
+			Path:            w.File,
+			StartLine:       line,
+			EndLine:         line,
+			AnnotationLevel: annotationLevel(w.Confidence),
+			Title:           w.WarningType,
+			Message:         w.Message,
+			RawDetails:      strings.Join(details, "\n"),
+		})
+	}
+	return annotations
+}
@@ -18,12 +18,13 @@ import (
 func AuthCheck(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		// check if valid github Post
+		// check if valid github Post, the signature itself is verified by the handler
+		xsig256 := r.Header.Get("X-Hub-Signature-256")
 		xsig := r.Header.Get("X-Hub-Signature")
 		xguid := r.Header.Get("X-GitHub-Delivery")
 		xevent := r.Header.Get("X-GitHub-Event")
 
-		if xsig == "" || xguid == "" || xevent == "" {
+		if (xsig256 == "" && xsig == "") || xguid == "" || xevent == "" {
 			w.WriteHeader(401)
 			w.Write([]byte("Nope!"))
 			return
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - baseline
+// Contains the logic to get the warnings a pull request or push is compared with
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ci-brakeman/github"
+	"github.com/ci-brakeman/logger"
+	"github.com/ci-brakeman/scanner"
+	"github.com/ci-brakeman/store"
+)
+
+// baseWarnings returns the warnings of the base commit of a pull request.
+// A base commit that was scanned before with the same options is taken from
+// the scan history, otherwise it is scanned and the result is added to the history.
+func baseWarnings(ctx context.Context, gh *github.Client, req scanRequest, opts scanner.Options) ([]scanner.WarningInfo, error) {
+	rec, err := history.FindCommit(req.Owner, req.Repo, req.BaseSHA, opts.Key())
+	if err != nil {
+		logger.Error(err)
+	}
+	if rec != nil {
+		logger.CreateBreadcrumb("baseWarnings", fmt.Sprintf("using stored scan of %s/%s@%s", req.Owner, req.Repo, req.BaseSHA))
+		return rec.Warnings, nil
+	}
+
+	ws, err := workspaces.Acquire(fmt.Sprintf("%s/%s@%s", req.Owner, req.Repo, req.BaseRef))
+	if err != nil {
+		return nil, err
+	}
+	defer ws.Release()
+
+	rec = &store.Record{
+		Owner:       req.Owner,
+		Repo:        req.Repo,
+		Ref:         req.BaseRef,
+		HeadSHA:     req.BaseSHA,
+		ScanOptions: opts.Key(),
+		StartedAt:   time.Now(),
+	}
+
+	scannedSHA, err := gh.CloneGitRepository(ctx, req.BaseRepoURL, req.BaseRef, req.BaseSHA, ws.Dir)
+	if err != nil {
+		return nil, err
+	}
+	rec.ScannedSHA = scannedSHA
+
+	finding, errorBit, err := scanner.ScanFolder(ctx, ws.Dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	if errorBit != 0 {
+		return nil, fmt.Errorf("base commit %s could not be scanned", req.BaseSHA)
+	}
+
+	rec.CompletedAt = time.Now()
+	rec.Duration = rec.CompletedAt.Sub(rec.StartedAt).Seconds()
+	rec.BrakemanVersion = finding.ScanInfo.BrakemanVersion
+	rec.ScanInfo = finding.ScanInfo
+	rec.Warnings = finding.Warnings
+	if err := history.Save(rec); err != nil {
+		logger.Error(err)
+	}
+	return finding.Warnings, nil
+}
+
+// branchBaseline returns the previous scan of a pushed branch, which is the
+// baseline the push is compared with
+func branchBaseline(req scanRequest, opts scanner.Options) (*store.Record, error) {
+	rec, err := history.Baseline(req.Owner, req.Repo, req.HeadRef, opts.Key())
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("no earlier scan of %s/%s@%s", req.Owner, req.Repo, req.HeadRef)
+	}
+	return rec, nil
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - comment
+// Contains the logic to keep a single report comment on a pull request
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ci-brakeman/github"
+	"github.com/ci-brakeman/logger"
+	"github.com/ci-brakeman/scanner"
+)
+
+// commentMarker is hidden at the end of the report comment, followed by the
+// digest of the findings, so the comment can be found again on the next scan
+const commentMarker = "<!-- ci-brakeman report digest="
+
+// findingsDigest identifies what the report says, the same conclusion with the
+// same new and fixed warnings gives the same digest
+func findingsDigest(conclusion string, diff scanner.Diff) string {
+	var fingerprints []string
+	for _, w := range diff.New {
+		fingerprints = append(fingerprints, "new:"+w.FingerPrint)
+	}
+	for _, w := range diff.Fixed {
+		fingerprints = append(fingerprints, "fixed:"+w.FingerPrint)
+	}
+	sort.Strings(fingerprints)
+
+	sum := sha256.Sum256([]byte(conclusion + "\n" + strings.Join(fingerprints, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// lastReportComment returns the most recent report comment of the App on a
+// pull request and the digest it was posted with, or nil if there is none
+func lastReportComment(comments []github.IssueComment) (*github.IssueComment, string) {
+	for i := len(comments) - 1; i >= 0; i-- {
+		c := &comments[i]
+		// users and other Apps could quote the marker, only comments of this App count
+		if c.Body == nil || c.ID == nil || c.User == nil || c.User.Login == nil || *c.User.Login != botLogin {
+			continue
+		}
+		at := strings.LastIndex(*c.Body, commentMarker)
+		if at < 0 {
+			continue
+		}
+		digest := strings.TrimPrefix((*c.Body)[at:], commentMarker)
+		if end := strings.Index(digest, " -->"); end >= 0 {
+			digest = digest[:end]
+		}
+		return c, digest
+	}
+	return nil, ""
+}
+
+// postReport keeps the report in a single comment on the pull request. The
+// last report comment is edited in place while the findings stay the same, a
+// new comment is only posted when they change. With throttle a new comment is
+// posted at most once per DIFF_MINUTES. onlyUpdate doesn't post a new comment
+// at all, but still brings an existing one up to date.
+func postReport(gh *github.Client, req scanRequest, text, digest string, onlyUpdate, throttle bool) error {
+	body := fmt.Sprintf("%s\n%s%s -->\n", text, commentMarker, digest)
+
+	number, _ := strconv.Atoi(req.Number)
+	comments, _, err := gh.ListIssueComments(req.Owner, req.Repo, number)
+	if err != nil {
+		// better to post the report again than to not report at all
+		logger.Error(err)
+	}
+	prev, prevDigest := lastReportComment(comments)
+
+	update := prev != nil && (prevDigest == digest || onlyUpdate ||
+		(throttle && prev.CreatedAt != nil && time.Since(*prev.CreatedAt) < DIFF_MINUTES*time.Minute))
+	if update {
+		logger.CreateBreadcrumb("postReport", fmt.Sprintf("repo=%s/%s,number=%s,updating comment %d", req.Owner, req.Repo, req.Number, *prev.ID))
+		return gh.UpdateIssueComment(req.Owner, req.Repo, *prev.ID, body)
+	}
+	if onlyUpdate {
+		return nil
+	}
+
+	return gh.PostCommentToGit(req.Owner, req.Repo, req.Number, body)
+}
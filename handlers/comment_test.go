@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package handlers
+
+import (
+	"testing"
+
+	"github.com/ci-brakeman/github"
+)
+
+func TestLastReportComment(t *testing.T) {
+	defer func(login string) { botLogin = login }(botLogin)
+	botLogin = "ci-brakeman[bot]"
+
+	comment := func(id int64, login, kind, body string) github.IssueComment {
+		return github.IssueComment{ID: &id, Body: &body, User: &github.User{Login: &login, Type: &kind}}
+	}
+	report := "report\n" + commentMarker + "abc -->\n"
+
+	tests := []struct {
+		name     string
+		comments []github.IssueComment
+		wantID   int64
+		digest   string
+	}{
+		{
+			name: "no comments",
+		},
+		{
+			name:     "report of the App",
+			comments: []github.IssueComment{comment(1, "ci-brakeman[bot]", "Bot", report)},
+			wantID:   1,
+			digest:   "abc",
+		},
+		{
+			name: "latest report wins",
+			comments: []github.IssueComment{
+				comment(1, "ci-brakeman[bot]", "Bot", report),
+				comment(2, "ci-brakeman[bot]", "Bot", "report\n"+commentMarker+"def -->\n"),
+			},
+			wantID: 2,
+			digest: "def",
+		},
+		{
+			name: "other bots quoting the marker",
+			comments: []github.IssueComment{
+				comment(1, "ci-brakeman[bot]", "Bot", report),
+				comment(2, "dependabot[bot]", "Bot", "> "+report),
+			},
+			wantID: 1,
+			digest: "abc",
+		},
+		{
+			name:     "user quoting the marker",
+			comments: []github.IssueComment{comment(1, "octocat", "User", report)},
+		},
+		{
+			name:     "comment of the App without a report",
+			comments: []github.IssueComment{comment(1, "ci-brakeman[bot]", "Bot", "warning ignored")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, digest := lastReportComment(tt.comments)
+			var id int64
+			if c != nil {
+				id = *c.ID
+			}
+			if id != tt.wantID || digest != tt.digest {
+				t.Errorf("lastReportComment() = %d, %q, want %d, %q", id, digest, tt.wantID, tt.digest)
+			}
+		})
+	}
+}
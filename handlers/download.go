@@ -26,7 +26,7 @@ func DownloadRaw(tmpFolder, filename, url string) error {
 
 // downloadRaw downloads the raw content of file from GitHub
 // this uses the URL retrieved using the GetContents API call
-// meaning it still goes through https://api.github.com/ and the Auth
+// meaning it still goes through the GitHub API and the Auth
 // token is thus still valid
 func downloadRaw(tmpFolder, filename, url string) error {
 	fmt.Println(tmpFolder)
@@ -84,7 +84,7 @@ func downloadRaw(tmpFolder, filename, url string) error {
 // smaller than 1Mb, meaning we need to use additional API calls to get the
 // raw file via the API (need to use the API as the Auth token is scoped to the API)
 // this uses the GitHub Tree API to retrieve the URL to the raw blob
-func downloadRawLarge(tmpFolder, owner, repo, filename, sha string) error {
+func downloadRawLarge(gh *github.Client, tmpFolder, owner, repo, filename, sha string) error {
 	fmt.Println(tmpFolder)
 	logger.CreateBreadcrumb("downloadRawLarge", fmt.Sprintf("filename=%s", filename))
 
@@ -108,7 +108,7 @@ func downloadRawLarge(tmpFolder, owner, repo, filename, sha string) error {
 	}
 	defer tmpfile.Close()
 
-	blob, resp, err := github.GetFileFromTree(owner, repo, filename, sha)
+	blob, resp, err := gh.GetFileFromTree(owner, repo, filename, sha)
 
 	if err != nil {
 		if resp != nil {
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - fork
+// Contains the logic to decide whether pull requests from forks are scanned
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ci-brakeman/github"
+	"github.com/ci-brakeman/logger"
+	"github.com/tidwall/gjson"
+)
+
+// the ways pull requests from forks can be dealt with
+const (
+	// ForkScan scans pull requests from forks like any other
+	ForkScan = "scan"
+	// ForkSkip doesn't scan pull requests from forks
+	ForkSkip = "skip"
+	// ForkApproval only scans the head of a pull request from a fork that a
+	// maintainer approved by adding the fork label
+	ForkApproval = "approval"
+)
+
+// isForkPullRequest reports whether the pull request of a pull_request event
+// comes from another repository. A deleted head repository was a fork.
+func isForkPullRequest(body []byte) bool {
+	head := gjson.GetBytes(body, "pull_request.head.repo.full_name").String()
+	return head != gjson.GetBytes(body, "pull_request.base.repo.full_name").String()
+}
+
+// isForkApproval reports whether a pull_request event is a maintainer adding
+// the fork label, which starts the scan of the head the pull request from a
+// fork has in the event
+func isForkApproval(action string, body []byte) bool {
+	return forkPolicy == ForkApproval && action == "labeled" &&
+		gjson.GetBytes(body, "label.name").String() == forkLabel && isForkPullRequest(body)
+}
+
+// forkAllowed reports whether a pull request from a fork may be scanned
+// under the fork policy. approved is set for the event approving its head,
+// the label alone doesn't approve the commits pushed after it was added.
+func forkAllowed(approved bool) bool {
+	switch forkPolicy {
+	case ForkSkip:
+		return false
+	case ForkApproval:
+		return approved
+	}
+	return true
+}
+
+// eventLabels returns the labels of the pull request of a pull_request event
+func eventLabels(body []byte) (labels []string) {
+	for _, l := range gjson.GetBytes(body, "pull_request.labels.#.name").Array() {
+		labels = append(labels, l.String())
+	}
+	return
+}
+
+// pullRequestLabels returns the labels of a pull request fetched from the API
+func pullRequestLabels(pr *github.PullRequest) (labels []string) {
+	for _, l := range pr.Labels {
+		if l.Name != nil {
+			labels = append(labels, *l.Name)
+		}
+	}
+	return
+}
+
+// skipFork reports on the check of a pull request from a fork why it wasn't
+// scanned. Skipped forks get no check at all, forks waiting for approval get
+// a neutral check telling maintainers how to start the scan. The fork label
+// of an earlier approval is removed, so adding it again approves the new head.
+func skipFork(gh *github.Client, req scanRequest, labels []string) {
+	logger.CreateBreadcrumb("skipFork", fmt.Sprintf("repo=%s/%s,number=%s,policy=%s", req.Owner, req.Repo, req.Number, forkPolicy))
+	if forkPolicy != ForkApproval {
+		return
+	}
+
+	for _, l := range labels {
+		if l == forkLabel {
+			number, _ := strconv.Atoi(req.Number)
+			if err := gh.RemoveIssueLabel(req.Owner, req.Repo, number, forkLabel); err != nil {
+				logger.Error(err)
+			}
+			break
+		}
+	}
+
+	checkRunID, err := gh.CreateGitCheckRun(req.Owner, req.Repo, req.HeadSHA)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	text := fmt.Sprintf("Pull requests from forks are only scanned once a maintainer approves them. Add the label `%s` to scan commit %s, every later commit needs to be approved again.", forkLabel, req.HeadSHA)
+	if err := gh.CompleteGitCheckRun(req.Owner, req.Repo, req.HeadSHA, checkRunID, text, "neutral", nil, nil); err != nil {
+		logger.Error(err)
+	}
+}
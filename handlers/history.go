@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - history
+// Contains the endpoint returning the scan history of a repository
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ci-brakeman/logger"
+	"github.com/ci-brakeman/store"
+)
+
+// History returns the scans of the repository given as ?repo=owner/name as
+// JSON, oldest first, along with the last scan that passed. With ?limit=n only
+// the n most recent scans are returned. The records name pull requests and
+// hold the warnings of the code, so they are only shown with the status token.
+func History(w http.ResponseWriter, r *http.Request) {
+	if !statusAuthorized(r) {
+		w.WriteHeader(401)
+		w.Write([]byte("Nope!"))
+		return
+	}
+
+	name := strings.SplitN(r.URL.Query().Get("repo"), "/", 2)
+	var limit int
+	var err error
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+	}
+	if len(name) != 2 || err != nil || limit < 0 {
+		w.WriteHeader(400)
+		w.Write([]byte("Expected ?repo=owner/name and an optional ?limit=n"))
+		return
+	}
+
+	owner, repo := name[0], name[1]
+	records, err := history.History(owner, repo)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("Couldn't read the history of " + owner + "/" + repo))
+		logger.Error(err)
+		return
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	passing, err := history.LastPassing(owner, repo)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error!"))
+		logger.Error(err)
+		return
+	}
+
+	body, err := json.Marshal(struct {
+		LastPassing *store.Record  `json:"last_passing"`
+		Scans       []store.Record `json:"scans"`
+	}{passing, records})
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error!"))
+		logger.Error(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	if _, err := w.Write(body); err != nil {
+		logger.Error(err)
+	}
+}
@@ -10,24 +10,36 @@
 package handlers
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"hash"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"path"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ci-brakeman/config"
 	"github.com/ci-brakeman/github"
 	"github.com/ci-brakeman/logger"
+	"github.com/ci-brakeman/policy"
+	"github.com/ci-brakeman/queue"
+	"github.com/ci-brakeman/report"
+	"github.com/ci-brakeman/sarif"
 	"github.com/ci-brakeman/scanner"
+	"github.com/ci-brakeman/store"
 	"github.com/tidwall/gjson"
 )
 
-//DIFF_MINUTES how many minutes between re-reporting the same issue
-const DIFF_MINUTES = 60 // 15 minutes * 60
+// DIFF_MINUTES how many minutes have to pass before changed findings are
+// reported in a new comment, when the repository throttles its comments
+const DIFF_MINUTES = 60
 
 // defining a Header type to access response headers
 type Header map[string][]string
@@ -45,28 +57,110 @@ func Catcher(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	xsig256 := []byte(r.Header.Get("X-Hub-Signature-256"))
 	xsig := []byte(r.Header.Get("X-Hub-Signature"))
 
-	if checkSignature(body, xsig) == false {
+	if checkSignature(body, xsig256, xsig) == false {
 		w.WriteHeader(401)
 		w.Write([]byte("Signature mismatch!"))
 		logger.Message("Unauthorized, signature mismatch")
 		return
 	}
-	respstatus := 404
-	respbody := []byte("")
+	guid := r.Header.Get("X-GitHub-Delivery")
 	// get event type
 	event := r.Header.Get("X-GitHub-Event")
+	if isReplay(event, body) {
+		w.WriteHeader(400)
+		w.Write([]byte("Delivery too old!"))
+		logger.Message(fmt.Sprintf("Rejecting delivery %s, payload is older than %s", guid, maxPayloadAge))
+		return
+	}
+	// retries and redeliveries of a delivery that was processed before are acknowledged but skipped
+	if !deliveries.Claim(guid) {
+		w.WriteHeader(200)
+		w.Write([]byte("duplicate delivery"))
+		logger.Message(fmt.Sprintf("Skipping duplicate delivery %s", guid))
+		return
+	}
+
+	respstatus := 404
+	respbody := []byte("")
 	// hand off to relevant handlers
 	switch event {
 	case "pull_request":
-		// can't wait for the scan to finish since large scans will timeout
-		// so send 200 response
-		respstatus = 200
-		respbody = []byte("received")
+		action := gjson.GetBytes(body, "action").String()
+		key := pullReqKey(body)
+
+		if action == "closed" {
+			// nothing left to scan, stop the queued and running scans of the pull request
+			n := jobQueue.Cancel(key)
+			respstatus = 200
+			respbody = []byte(fmt.Sprintf("cancelled %d scan(s)", n))
+			logger.CreateBreadcrumb("Catcher", fmt.Sprintf("pull request %s closed, cancelled %d scan(s)", key, n))
+			break
+		}
+		// a maintainer labelling a pull request from a fork approves its scan
+		if !scanActions[action] && !isForkApproval(action, body) {
+			respstatus = 200
+			respbody = []byte("ignored action")
+			break
+		}
+
+		respstatus, respbody = enqueue(&queue.Job{
+			ID:  guid,
+			Key: key,
+			Run: func(ctx context.Context) { pullReqEvent(ctx, guid, body) },
+		})
+
+		break
+	case "push":
+		if !isBranchPush(body) {
+			respstatus = 200
+			respbody = []byte("ignored push")
+			break
+		}
 
-		// trigger handler for the event
-		go pullReqEvent(body)
+		respstatus, respbody = enqueue(&queue.Job{
+			ID:  guid,
+			Key: pushKey(body),
+			Run: func(ctx context.Context) { pushEvent(ctx, guid, body) },
+		})
+
+		break
+	case "check_suite", "check_run":
+		if isIgnoreAction(event, body) {
+			respstatus, respbody = enqueue(&queue.Job{
+				ID:  guid,
+				Key: ignoreKey(event, body),
+				Run: func(ctx context.Context) { requestedActionEvent(ctx, guid, body) },
+			})
+			break
+		}
+		if !isRerequest(event, body) {
+			respstatus = 200
+			respbody = []byte("ignored action")
+			break
+		}
+
+		respstatus, respbody = enqueue(&queue.Job{
+			ID:  guid,
+			Key: rerunKey(event, body),
+			Run: func(ctx context.Context) { rerunEvent(ctx, guid, event, body) },
+		})
+
+		break
+	case "issue_comment":
+		if !isIgnoreComment(body) {
+			respstatus = 200
+			respbody = []byte("ignored comment")
+			break
+		}
+
+		respstatus, respbody = enqueue(&queue.Job{
+			ID:  guid,
+			Key: ignoreKey(event, body),
+			Run: func(ctx context.Context) { ignoreCommentEvent(ctx, guid, body) },
+		})
 
 		break
 	default:
@@ -75,6 +169,11 @@ func Catcher(w http.ResponseWriter, r *http.Request) {
 		fmt.Printf("Unsupported event %s\n", event)
 	}
 
+	if respstatus >= 500 {
+		// the delivery wasn't processed, so accept it when it is delivered again
+		deliveries.Release(guid)
+	}
+
 	w.WriteHeader(respstatus)
 	if respbody != nil {
 		_, err := w.Write(respbody)
@@ -84,149 +183,420 @@ func Catcher(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// checkSignature verifies that the supplied message has been signed with our
-// secret. Annoyingly can't do this in auth middle-ware as it would mean having to pass through the body
-func checkSignature(body, xsignature []byte) bool {
-	secret := []byte(os.Getenv("GITHUB_SECRET"))
+// enqueue queues a scan job and returns the response to the webhook. We
+// can't wait for the scan to finish since large scans will timeout, so the
+// response is sent as soon as the job is queued.
+func enqueue(job *queue.Job) (int, []byte) {
+	if err := jobQueue.Enqueue(job); err != nil {
+		// the queue is full, ask GitHub to deliver the event again later
+		logger.Error(fmt.Errorf("rejecting job=%s,key=%s: %s", job.ID, job.Key, err))
+		return 503, []byte("scan queue is full, retry later")
+	}
+	return 200, []byte("received")
+}
+
+// checkSignature verifies that the supplied message has been signed with one of our
+// secrets. Annoyingly can't do this in auth middle-ware as it would mean having to pass through the body
+// The SHA-256 signature is used whenever GitHub sends it, the legacy SHA-1 signature
+// is only accepted when ALLOW_SHA1_SIGNATURE is set to true.
+func checkSignature(body, xsignature256, xsignature []byte) bool {
+	hash, prefix, signature := sha256.New, "sha256", xsignature256
+	if len(signature) == 0 {
+		if os.Getenv("ALLOW_SHA1_SIGNATURE") != "true" {
+			return false
+		}
+		hash, prefix, signature = sha1.New, "sha1", xsignature
+	}
+	if len(signature) == 0 {
+		return false
+	}
 
-	if len(secret) != 0 && len(xsignature) != 0 {
-		// check if signature matches
-		// https://developer.github.com/webhooks/securing/
-		// The HMAC hex digest is generated using the sha1 hash function and the secret as the HMAC key
-		return checkHMAC(body, xsignature, secret)
+	// check if signature matches any of the active secrets
+	// https://docs.github.com/en/developers/webhooks-and-events/webhooks/securing-your-webhooks
+	// The HMAC hex digest is generated using the hash function and the secret as the HMAC key
+	for _, secret := range webhookSecrets() {
+		if checkHMAC(body, signature, secret, hash, prefix) {
+			return true
+		}
 	}
 
 	return false
 }
 
+// webhookSecrets returns the secrets deliveries may be signed with. GITHUB_SECRET
+// holds the current secret, GITHUB_SECRETS can hold a comma separated list of
+// further secrets that are still accepted while the secret is being rotated.
+func webhookSecrets() (secrets [][]byte) {
+	if secret := os.Getenv("GITHUB_SECRET"); secret != "" {
+		secrets = append(secrets, []byte(secret))
+	}
+	for _, secret := range strings.Split(os.Getenv("GITHUB_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			secrets = append(secrets, []byte(secret))
+		}
+	}
+	return
+}
+
 // checkMAC reports whether messageMAC is a valid HMAC tag for message.
-func checkHMAC(message, messageMAC, key []byte) bool {
-	mac := hmac.New(sha1.New, key)
+func checkHMAC(message, messageMAC, key []byte, hash func() hash.Hash, prefix string) bool {
+	mac := hmac.New(hash, key)
 	mac.Write(message)
 	expectedMAC := mac.Sum(nil)
 
-	// we need to prepend sha1= to the message signature, since the supplied header is sha1=AAAA
-	encodedMAC := fmt.Sprintf("sha1=%s", hex.EncodeToString(expectedMAC))
-
-	//fmt.Printf("%s,%s\n", messageMAC, encodedMAC)
+	// we need to prepend the hash name to the message signature, since the supplied header is sha256=AAAA
+	encodedMAC := fmt.Sprintf("%s=%s", prefix, hex.EncodeToString(expectedMAC))
 
 	return hmac.Equal(messageMAC, []byte(encodedMAC))
 }
 
+// scanRequest holds everything a scan job needs to know about what to scan.
+// Scans of pushes have no pull request number and no base.
+type scanRequest struct {
+	Event          string
+	DeliveryID     string
+	InstallationID string
+	Number         string
+	Owner          string
+	Repo           string
+	HeadSHA        string
+	HeadRef        string
+	RepoURL        string
+	BaseSHA        string
+	BaseRef        string
+	BaseRepoURL    string
+	// Fork is set for pull requests from another repository than the one they
+	// are opened in. Owner, Repo and RepoURL are always the base repository.
+	Fork bool
+}
+
+// isPullRequest reports whether the scan is for a pull request rather than a push
+func (req scanRequest) isPullRequest() bool {
+	return req.Number != ""
+}
+
+// gitRef returns the ref the scanned commit belongs to
+func (req scanRequest) gitRef() string {
+	if req.isPullRequest() {
+		return fmt.Sprintf("refs/pull/%s/head", req.Number)
+	}
+	return "refs/heads/" + req.HeadRef
+}
+
+// mergeRef returns the ref of the commit merging a pull request into its base
+func (req scanRequest) mergeRef() string {
+	return fmt.Sprintf("refs/pull/%s/merge", req.Number)
+}
+
+// key identifies what is scanned, the same way the scan job does
+func (req scanRequest) key() string {
+	if req.isPullRequest() {
+		return fmt.Sprintf("%s/%s#%s", req.Owner, req.Repo, req.Number)
+	}
+	return fmt.Sprintf("%s/%s@%s", req.Owner, req.Repo, req.HeadRef)
+}
+
+// this function is used to process the pull request or push and download the files
+
+func processScan(ctx context.Context, req scanRequest) {
+	// every job gets its own workspace, so concurrent scans can't see each other's files
+	ws, err := workspaces.Acquire(req.key())
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	// delete the workspace containing the code to be scanned, also when the scan panics
+	defer ws.Release()
+
+	rec := &store.Record{
+		Event:      req.Event,
+		Owner:      req.Owner,
+		Repo:       req.Repo,
+		Ref:        req.HeadRef,
+		HeadSHA:    req.HeadSHA,
+		BaseSHA:    req.BaseSHA,
+		DeliveryID: req.DeliveryID,
+		StartedAt:  time.Now(),
+	}
+	rec.PullNumber, _ = strconv.Atoi(req.Number)
+	// keep a record of every scan, whatever the outcome
+	defer saveRecord(rec)
+
+	gh := installations.Client(req.InstallationID)
 
-// this function is used to process the pull request and download the files
+	// Creating a Check Run for the Pull Request or pushed commit
+	checkRunID, err := gh.CreateGitCheckRun(req.Owner, req.Repo, req.HeadSHA)
+	if err != nil {
+		rec.Error = err.Error()
+		logger.Error(err)
+		return
+	}
+	rec.CheckRunID = checkRunID
 
-func processPullReq(number, owner, repo string, headSHA string, repoURL string) {
-	tmpFolder := "tmp"
-	// cleaning up the tmpFolder to ensure no residues from previous scan
-	cleanUp(tmpFolder)
-	// Creating a Check Run for the Pull Request
-	checkRunID := github.CreateGitCheckRun(owner, repo, headSHA)
+	logger.CreateBreadcrumb("processScan", fmt.Sprintf("owner=%s, repo=%s, key=%s", req.Owner, req.Repo, req.key()))
 
-	logger.CreateBreadcrumb("processPullReq", fmt.Sprintf("owner=%s, repo=%s", owner, repo))
+	// the repository may change how it is scanned and reported with a configuration file
+	cfg, cfgErr := loadScanConfig(gh, req)
+	if cfgErr != nil {
+		logger.Error(cfgErr)
+	}
 
-	errClone := github.CloneGitRepository(repoURL, tmpFolder)
+	// only the commits to scan are fetched, and brakeman only runs once they are checked out
+	targets, notes, errClone := checkoutTargets(ctx, gh, req, cfg.ScanTarget, ws.Dir)
+	if ctx.Err() != nil {
+		cancelScan(gh, req, checkRunID, rec)
+		return
+	}
 	if errClone != nil {
 		fmt.Println("Error while cloning the repository")
-		logger.Error(errClone)
+		failScan(gh, req, checkRunID, rec, fmt.Sprintf("Commit %s could not be fetched, it was not scanned.", req.HeadSHA), errClone)
+		return
 	}
+	recordTargets(rec, targets)
+	req.BaseSHA = rec.BaseSHA
+
 	// scan all the downloaded files
-	err := scan(tmpFolder, number, owner, repo, checkRunID, headSHA, repoURL)
+	completed, err := scan(ctx, gh, targets, notes, req, cfg, cfgErr, checkRunID, rec)
+	if completed {
+		// the pull request may have been closed while the comment was posted, the result still stands
+		return
+	}
+	if ctx.Err() != nil {
+		cancelScan(gh, req, checkRunID, rec)
+		return
+	}
 	if err != nil {
-		logger.Error(err)
+		// a check run left in progress would block a branch that requires it
+		failScan(gh, req, checkRunID, rec, fmt.Sprintf("Commit %s could not be scanned: %s", req.HeadSHA, err), err)
 	}
-
-	// delete the tmpFolder containing the code to be scanned
-	defer func() {
-		if err := os.RemoveAll(tmpFolder); err != nil {
-			logger.Error(err)
-		}
-	}()
 }
 
-func scan(tmpFolder, number, owner, repo string, checkRunID string, headSHA string, repoURL string) (err error) {
-	logger.CreateBreadcrumb("scan", fmt.Sprintf("owner=%s,repo=%s,pullReqNumber=%s", owner, repo, number))
+// scan runs brakeman on the checked out commits, completes the check run with
+// the result and comments on the pull request. completed reports whether the
+// check run was completed, it is left to the caller otherwise.
+func scan(ctx context.Context, gh *github.Client, targets []scanTarget, notes []string, req scanRequest, cfg *config.Config, cfgErr error, checkRunID string, rec *store.Record) (completed bool, err error) {
+	logger.CreateBreadcrumb("scan", fmt.Sprintf("owner=%s,repo=%s,pullReqNumber=%s", req.Owner, req.Repo, req.Number))
+
+	opts := scanOptions(cfg)
+	rec.ScanOptions = opts.Key()
 
 	// scan
-	finding, errorBit, err := scanner.ScanFolder(tmpFolder)
-	var scanOutput string
+	finding, errorBit, err := scanTargets(ctx, targets, opts)
 	if err != nil {
 		logger.Error(err)
-		return err
+		return false, err
 	}
 
-	if errorBit == 0{
-		var warnings string = "CI-Brakeman Scan Result \\n"
-		if len(finding.Warnings) == 0 {
-			warnings = "No warnings"
-			scanOutput = "Findings: \\n" + warnings
+	var annotations []github.CheckRunAnnotation
+	var actions []github.CheckRunAction
+	var diff scanner.Diff
+	var decision policy.Decision
+	rep := &report.Report{
+		WebURL: gh.RepoURL(req.Owner, req.Repo),
+		// the code is linked in the first commit scanned, the head unless only the merge commit was scanned
+		HeadSHA: targets[0].SHA,
+		Notes:   notes,
+	}
+	for _, t := range targets {
+		rep.Scanned = append(rep.Scanned, report.Commit{Kind: t.Kind, SHA: t.SHA})
+	}
+	if cfgErr != nil {
+		rep.Notes = append(rep.Notes, fmt.Sprintf("The %s of the repository could not be used, the defaults were applied: %s", config.FileName, cfgErr))
+	}
+	if errorBit == 0 {
+		// compare with the base branch of a pull request, or the previous scan of a pushed branch,
+		// so only the warnings introduced by the change are reported
+		var base []scanner.WarningInfo
+		var baseErr error
+		baseMissing := "The base branch could not be scanned"
+		if req.isPullRequest() {
+			base, baseErr = baseWarnings(ctx, gh, req, opts)
 		} else {
-			for _,w := range finding.Warnings {
-				fPathURL := fmt.Sprintf("https://github.com/%s/%s/blob/%s/%s#L%s", owner, repo, headSHA, w.File, strconv.Itoa(w.Line))
-		
-				warnings = warnings + "Warning Type: "+string(w.WarningType)+"\\n"+"File: "+string(fPathURL)+"\\n\\n"
+			var baseline *store.Record
+			if baseline, baseErr = branchBaseline(req, opts); baseline != nil {
+				base = baseline.Warnings
+				req.BaseSHA = baseline.HeadSHA
+				rec.BaseSHA = baseline.HeadSHA
 			}
-			
-			scanOutput = "Findings: \\n" + warnings
-			//Complete the Check Run in the pull request
-			github.CompleteGitCheckRun(owner, repo, headSHA, checkRunID, scanOutput, "success")
+			baseMissing = "There is no earlier scan of the branch"
+		}
+		if baseErr != nil {
+			logger.Error(baseErr)
 		}
+		diff = scanner.DiffWarnings(base, finding.Warnings)
+		if baseErr != nil {
+			rep.Notes = append(rep.Notes, baseMissing+", all warnings are reported as new.")
+		} else {
+			rep.BaseSHA = req.BaseSHA
+		}
+		rep.Diff = diff
+		rep.Apps = finding.Apps
+
+		// only the warnings introduced by the pull request are annotated
+		annotations = annotationsFromWarnings(diff.New)
+		// the ignore file can only be committed to the branch of a pull request, not to a fork
+		if req.isPullRequest() && !req.Fork {
+			actions = ignoreActions(diff.New)
+		}
+		rec.NewWarnings = len(diff.New)
+
+		decision = cfg.Policy.Evaluate(diff)
+		rec.Conclusion = decision.Conclusion
+		rep.Violations = decision.Reasons
+	} else {
+		rep.Error = "The code could not be scanned."
+		if len(finding.Errors) > 0 {
+			rep.Error += " " + strings.Join(finding.Errors, " ")
+		}
+		rec.Conclusion = "failure"
+	}
+	rep.Conclusion = rec.Conclusion
+	scanOutput := rep.Markdown()
+	rec.BrakemanVersion = finding.ScanInfo.BrakemanVersion
+	rec.ScanInfo = finding.ScanInfo
+	rec.Warnings = finding.Warnings
+
+	if errorBit == 0 && os.Getenv("SARIF_UPLOAD") == "true" {
+		uploadSarif(gh, req, finding, rec.StartedAt)
+	}
+
+	//Complete the Check Run in the pull request
+	if err := gh.CompleteGitCheckRun(req.Owner, req.Repo, req.HeadSHA, checkRunID, scanOutput, rec.Conclusion, annotations, actions); err != nil {
+		return false, err
+	}
+	completed = true
 
-	}else {
-		scanOutput = "ERROR: Some error occured while scanning the pull request. Please contact the administrator of the tool."
-		github.CompleteGitCheckRun(owner, repo, headSHA, checkRunID, scanOutput, "failure")
+	// pushes have no pull request to comment on
+	if !req.isPullRequest() || !cfg.Comment.Enabled {
+		return
+	}
+
+	// mention the configured users and teams when the pull request needs their attention
+	notify := len(decision.Reasons) > 0 || (cfg.Notify.On == "new_warnings" && len(diff.New) > 0)
+	if notify && len(cfg.Notify.Mentions) > 0 {
+		scanOutput += "\ncc " + strings.Join(cfg.Notify.Mentions, " ") + "\n"
 	}
-	
 
-	// creating the PR comment body with the scanOutputString
-	scanOutputJSON := `{"body": "` + string(scanOutput) + `"}`
-	comment := string([]byte(scanOutputJSON))
+	// without new warnings there is nothing to comment on, but an earlier comment is still brought up to date
+	onlyUpdate := cfg.Comment.OnlyOnWarnings && errorBit == 0 && len(diff.New) == 0
 
-	//post comment to Github Pull Request
-	github.PostCommentToGit(owner, repo, number, comment)
+	//post or update the comment on the Github Pull Request
+	if err := postReport(gh, req, scanOutput, findingsDigest(rec.Conclusion, diff), onlyUpdate, cfg.Comment.Throttle); err != nil {
+		logger.Error(err)
+	}
 
-	cleanUp(tmpFolder)
 	return
 }
 
+// uploadSarif sends the findings to code scanning, where they show up next
+// to the results of other code scanning tools
+func uploadSarif(gh *github.Client, req scanRequest, finding scanner.Findings, startedAt time.Time) {
+	log, err := json.Marshal(sarif.FromFindings(finding))
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	ref := req.gitRef()
+	upload, err := gh.UploadSarif(req.Owner, req.Repo, req.HeadSHA, ref, log, startedAt)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	logger.CreateBreadcrumb("uploadSarif", fmt.Sprintf("repo=%s/%s,ref=%s,id=%s", req.Owner, req.Repo, ref, upload.ID))
+}
+
+// cancelScan marks the check run of a scan that was stopped because the
+// pull request was closed as cancelled
+func cancelScan(gh *github.Client, req scanRequest, checkRunID string, rec *store.Record) {
+	logger.CreateBreadcrumb("cancelScan", fmt.Sprintf("owner=%s,repo=%s,pullReqNumber=%s", req.Owner, req.Repo, req.Number))
+	rec.Conclusion = "cancelled"
+	rec.Error = ""
+	err := gh.CompleteGitCheckRun(req.Owner, req.Repo, req.HeadSHA, checkRunID, "The pull request was closed, the scan was cancelled.", "cancelled", nil, nil)
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+// failScan marks the check run of a scan that couldn't be completed as
+// failed, text tells the user why
+func failScan(gh *github.Client, req scanRequest, checkRunID string, rec *store.Record, text string, err error) {
+	logger.Error(err)
+	rec.Conclusion = "failure"
+	rec.Error = err.Error()
+	if err := gh.CompleteGitCheckRun(req.Owner, req.Repo, req.HeadSHA, checkRunID, text, "failure", nil, nil); err != nil {
+		logger.Error(err)
+	}
+}
+
+// saveRecord completes the record of a scan and adds it to the scan history
+func saveRecord(rec *store.Record) {
+	rec.CompletedAt = time.Now()
+	rec.Duration = rec.CompletedAt.Sub(rec.StartedAt).Seconds()
+	if rec.Conclusion == "" {
+		// the scan never got to a conclusion
+		rec.Conclusion = "failure"
+	}
+	if err := history.Save(rec); err != nil {
+		logger.Error(err)
+	}
+}
+
 // handler for pull request. If pushEvent function above is not needed, it can be deleted
 
-func pullReqEvent(body []byte) (int, []byte) {
+func pullReqEvent(ctx context.Context, deliveryID string, body []byte) (int, []byte) {
 	//https://developer.github.com/webhooks/event-payloads/#pull_request
 
-	number := gjson.GetBytes(body, "number").String()
 	// get repository name and owner
 	// could use full_name := heroku/reponame , but the api code expects owner and repo as strings
 	// this should already be known, but using the webhook data to avoid
-	// hardcoding these values
-	repo := gjson.GetBytes(body, "pull_request.head.repo.name").String()
-	owner := gjson.GetBytes(body, "pull_request.head.repo.owner.login").String()
-	headSHA := gjson.GetBytes(body, "pull_request.head.sha").String()
+	// hardcoding these values.
+	// The pull request is scanned in the base repository, which has the code of
+	// the head in refs/pull/<number>/head even when it comes from a fork the
+	// App isn't installed on.
+	req := scanRequest{
+		Event:          "pull_request",
+		DeliveryID:     deliveryID,
+		InstallationID: installationID(body),
+		Number:         gjson.GetBytes(body, "number").String(),
+		Repo:           gjson.GetBytes(body, "pull_request.base.repo.name").String(),
+		Owner:          gjson.GetBytes(body, "pull_request.base.repo.owner.login").String(),
+		HeadSHA:        gjson.GetBytes(body, "pull_request.head.sha").String(),
+		HeadRef:        gjson.GetBytes(body, "pull_request.head.ref").String(),
+		RepoURL:        gjson.GetBytes(body, "pull_request.base.repo.html_url").String(),
+		BaseSHA:        gjson.GetBytes(body, "pull_request.base.sha").String(),
+		BaseRef:        gjson.GetBytes(body, "pull_request.base.ref").String(),
+		BaseRepoURL:    gjson.GetBytes(body, "pull_request.base.repo.html_url").String(),
+		Fork:           isForkPullRequest(body),
+	}
 	// who created the pull request
 	puller := gjson.GetBytes(body, "pull_request.user.login").String()
-	repoURL := gjson.GetBytes(body, "pull_request.head.repo.html_url").String()
 
-	logger.CreateBreadcrumb("pullReqEvent", fmt.Sprintf("number=%s,repo=%s/%s,puller=%s", number, owner, repo, puller))
+	logger.CreateBreadcrumb("pullReqEvent", fmt.Sprintf("number=%s,repo=%s/%s,puller=%s,fork=%t", req.Number, req.Owner, req.Repo, puller, req.Fork))
 
+	if req.Fork && !forkAllowed(isForkApproval(gjson.GetBytes(body, "action").String(), body)) {
+		skipFork(installations.Client(req.InstallationID), req, eventLabels(body))
+		return 200, nil
+	}
 
-	processPullReq(number, owner, repo, headSHA, repoURL)
+	processScan(ctx, req)
 	return 200, nil
 }
 
-// function to clean up the files downloaded during the execution
-func cleanUp(tmpFolder string) {
-	fmt.Println("[cleanUp] Cleaning up the files")
-	files, err := ioutil.ReadDir(tmpFolder)
-	if err != nil {
-		logger.Error(err)
-	}
-
-	for _, f := range files {
-		err := os.RemoveAll(path.Join([]string{tmpFolder, f.Name()}...))
-		if err != nil {
-			logger.Error(err)
-		} 
+// installationID returns the ID of the GitHub App installation that sent the event.
+// All GitHub calls for an event are made on behalf of this installation.
+// GITHUB_INSTALLID is only used for events that don't carry an installation.
+func installationID(body []byte) string {
+	if id := gjson.GetBytes(body, "installation.id").String(); id != "" {
+		return id
 	}
-	fmt.Println("Cleanup complete.")
+	return os.Getenv("GITHUB_INSTALLID")
+}
 
-}
\ No newline at end of file
+// pullReqKey identifies the pull request a pull_request event is about
+func pullReqKey(body []byte) string {
+	return fmt.Sprintf("%s/%s#%s",
+		gjson.GetBytes(body, "repository.owner.login").String(),
+		gjson.GetBytes(body, "repository.name").String(),
+		gjson.GetBytes(body, "number").String())
+}
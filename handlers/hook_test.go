@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"reflect"
+	"testing"
+)
+
+// sign returns the signature header GitHub would send for body
+func sign(body []byte, secret string, h func() hash.Hash, prefix string) []byte {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return []byte(prefix + "=" + hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestCheckSignature(t *testing.T) {
+	body := []byte(`{"action":"opened"}`)
+
+	tests := []struct {
+		name       string
+		secrets    string
+		allowSHA1  string
+		sig256     []byte
+		sig1       []byte
+		authorized bool
+	}{
+		{
+			name:       "sha256 only",
+			sig256:     sign(body, "current", sha256.New, "sha256"),
+			authorized: true,
+		},
+		{
+			name:   "sha256 with the wrong secret",
+			sig256: sign(body, "other", sha256.New, "sha256"),
+		},
+		{
+			name:   "sha256 of another body",
+			sig256: sign([]byte(`{}`), "current", sha256.New, "sha256"),
+		},
+		{
+			name:   "sha256 with a sha1 prefix",
+			sig256: sign(body, "current", sha256.New, "sha1"),
+		},
+		{
+			name:      "bad sha256 and good sha1",
+			allowSHA1: "true",
+			sig256:    sign(body, "other", sha256.New, "sha256"),
+			sig1:      sign(body, "current", sha1.New, "sha1"),
+		},
+		{
+			name: "sha1 only",
+			sig1: sign(body, "current", sha1.New, "sha1"),
+		},
+		{
+			name:       "sha1 only when allowed",
+			allowSHA1:  "true",
+			sig1:       sign(body, "current", sha1.New, "sha1"),
+			authorized: true,
+		},
+		{
+			name:      "sha1 with the wrong secret when allowed",
+			allowSHA1: "true",
+			sig1:      sign(body, "other", sha1.New, "sha1"),
+		},
+		{
+			name:      "no signature",
+			allowSHA1: "true",
+		},
+		{
+			name:       "rotated secret",
+			secrets:    "old, older",
+			sig256:     sign(body, "older", sha256.New, "sha256"),
+			authorized: true,
+		},
+		{
+			name:    "secret that is no longer rotated",
+			secrets: "old",
+			sig256:  sign(body, "older", sha256.New, "sha256"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GITHUB_SECRET", "current")
+			t.Setenv("GITHUB_SECRETS", tt.secrets)
+			t.Setenv("ALLOW_SHA1_SIGNATURE", tt.allowSHA1)
+
+			if got := checkSignature(body, tt.sig256, tt.sig1); got != tt.authorized {
+				t.Errorf("checkSignature() = %v, want %v", got, tt.authorized)
+			}
+		})
+	}
+}
+
+func TestCheckSignatureWithoutSecret(t *testing.T) {
+	t.Setenv("GITHUB_SECRET", "")
+	t.Setenv("GITHUB_SECRETS", "")
+
+	body := []byte(`{}`)
+	if checkSignature(body, sign(body, "", sha256.New, "sha256"), nil) {
+		t.Error("checkSignature() accepted a delivery without a configured secret")
+	}
+}
+
+func TestWebhookSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		secrets string
+		want    []string
+	}{
+		{name: "none"},
+		{name: "current only", secret: "current", want: []string{"current"}},
+		{name: "rotated only", secrets: "old", want: []string{"old"}},
+		{name: "current and rotated", secret: "current", secrets: " old ,, older ", want: []string{"current", "old", "older"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GITHUB_SECRET", tt.secret)
+			t.Setenv("GITHUB_SECRETS", tt.secrets)
+
+			var got []string
+			for _, s := range webhookSecrets() {
+				got = append(got, string(s))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("webhookSecrets() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckHMAC(t *testing.T) {
+	body := []byte("payload")
+
+	tests := []struct {
+		name   string
+		mac    []byte
+		hash   func() hash.Hash
+		prefix string
+		valid  bool
+	}{
+		{name: "sha256", mac: sign(body, "key", sha256.New, "sha256"), hash: sha256.New, prefix: "sha256", valid: true},
+		{name: "sha1", mac: sign(body, "key", sha1.New, "sha1"), hash: sha1.New, prefix: "sha1", valid: true},
+		{name: "sha1 tag checked as sha256", mac: sign(body, "key", sha1.New, "sha1"), hash: sha256.New, prefix: "sha256"},
+		{name: "missing prefix", mac: sign(body, "key", sha256.New, "")[1:], hash: sha256.New, prefix: "sha256"},
+		{name: "wrong key", mac: sign(body, "other", sha256.New, "sha256"), hash: sha256.New, prefix: "sha256"},
+		{name: "empty", hash: sha256.New, prefix: "sha256"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkHMAC(body, tt.mac, []byte("key"), tt.hash, tt.prefix); got != tt.valid {
+				t.Errorf("checkHMAC() = %v, want %v", got, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - ignore
+// Contains the logic to ignore a warning from the check run or a pull request comment
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/ci-brakeman/github"
+	"github.com/ci-brakeman/logger"
+	"github.com/ci-brakeman/scanner"
+	"github.com/ci-brakeman/store"
+	"github.com/tidwall/gjson"
+)
+
+// ignoreActionPrefix starts the identifier of the "Ignore" buttons, followed
+// by the start of the fingerprint. Identifiers are limited to 20 characters.
+const ignoreActionPrefix = "ignore:"
+
+// ignoreCommand is the pull request comment that ignores a warning, followed
+// by the fingerprint and a note why the warning can be ignored
+const ignoreCommand = "/brakeman ignore"
+
+// maxCheckRunActions is the number of buttons a check run can have
+const maxCheckRunActions = 3
+
+// minFingerprintPrefix is the shortest start of a fingerprint accepted in a comment
+const minFingerprintPrefix = 8
+
+// ignoreActions returns an "Ignore" button for the first new warnings of a scan
+func ignoreActions(warnings []scanner.WarningInfo) (actions []github.CheckRunAction) {
+	for i, w := range warnings {
+		if i == maxCheckRunActions {
+			break
+		}
+		actions = append(actions, github.CheckRunAction{
+			Label:       fmt.Sprintf("Ignore warning %d", i+1),
+			Description: truncate(fmt.Sprintf("%s in %s", w.WarningType, path.Base(w.File)), 40),
+			Identifier:  truncate(ignoreActionPrefix+w.FingerPrint, 20),
+		})
+	}
+	return
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// isIgnoreAction reports whether a check_run event is a click on one of our "Ignore" buttons
+func isIgnoreAction(event string, body []byte) bool {
+	return event == "check_run" &&
+		gjson.GetBytes(body, "action").String() == "requested_action" &&
+		gjson.GetBytes(body, "check_run.name").String() == github.CheckRunName &&
+		strings.HasPrefix(gjson.GetBytes(body, "requested_action.identifier").String(), ignoreActionPrefix)
+}
+
+// isIgnoreComment reports whether an issue_comment event is a new comment on a
+// pull request asking to ignore a warning
+func isIgnoreComment(body []byte) bool {
+	return gjson.GetBytes(body, "action").String() == "created" &&
+		gjson.GetBytes(body, "issue.pull_request").Exists() &&
+		strings.HasPrefix(strings.TrimSpace(gjson.GetBytes(body, "comment.body").String()), ignoreCommand)
+}
+
+// ignoreRequest holds who wants to ignore which warning of which pull request
+type ignoreRequest struct {
+	InstallationID string
+	Owner          string
+	Repo           string
+	Number         int
+	User           string
+	// Fingerprint is the fingerprint of the warning, or the start of it
+	Fingerprint string
+	Note        string
+}
+
+// handler for check_run requested_action. A button can't take the note why
+// the warning can be ignored, so the user is asked for it with a comment
+// holding the ignore command for the warning. The button only carries the
+// start of the fingerprint, the warning is looked up in the scan shown on the
+// check run.
+func requestedActionEvent(ctx context.Context, deliveryID string, body []byte) (int, []byte) {
+	//https://docs.github.com/en/developers/webhooks-and-events/webhooks/webhook-events-and-payloads#check_run
+
+	req := ignoreRequest{
+		InstallationID: installationID(body),
+		Owner:          gjson.GetBytes(body, "repository.owner.login").String(),
+		Repo:           gjson.GetBytes(body, "repository.name").String(),
+		Number:         int(gjson.GetBytes(body, "check_run.pull_requests.0.number").Int()),
+		User:           gjson.GetBytes(body, "sender.login").String(),
+		Fingerprint:    strings.TrimPrefix(gjson.GetBytes(body, "requested_action.identifier").String(), ignoreActionPrefix),
+	}
+	checkRunID := gjson.GetBytes(body, "check_run.id").String()
+
+	logger.CreateBreadcrumb("requestedActionEvent", fmt.Sprintf("repo=%s/%s,checkRun=%s,fingerprint=%s,sender=%s", req.Owner, req.Repo, checkRunID, req.Fingerprint, req.User))
+
+	if req.Number == 0 {
+		logger.Infof("check run %s of %s/%s has no pull request, not ignoring %s", checkRunID, req.Owner, req.Repo, req.Fingerprint)
+		return 200, nil
+	}
+
+	rec, err := history.FindCheckRun(req.Owner, req.Repo, checkRunID)
+	if err != nil {
+		logger.Error(err)
+		return 500, nil
+	}
+
+	msg := ignorePrompt(req, rec)
+	gh := installations.Client(req.InstallationID)
+	if err := gh.PostCommentToGit(req.Owner, req.Repo, fmt.Sprint(req.Number), msg); err != nil {
+		logger.Error(err)
+	}
+	return 200, nil
+}
+
+// ignorePrompt returns the comment asking the user who clicked an "Ignore"
+// button for the note, with the ignore command of the warning to reply with
+func ignorePrompt(req ignoreRequest, rec *store.Record) string {
+	if rec == nil {
+		return fmt.Sprintf("@%s the scan of the check run is not known anymore, the warning can be ignored with `%s <fingerprint> <note>`.", req.User, ignoreCommand)
+	}
+	w, err := findWarning(rec.Warnings, req.Fingerprint)
+	if err != nil {
+		return fmt.Sprintf("@%s the warning could not be ignored: %s", req.User, err)
+	}
+	return fmt.Sprintf("@%s to ignore the %s warning in `%s`, reply with a note why it can be ignored:\n\n```\n%s %s <note>\n```", req.User, w.WarningType, w.File, ignoreCommand, w.FingerPrint)
+}
+
+// handler for issue_comment created on a pull request with the ignore command.
+// The warning is looked up in the last scan of the head of the pull request.
+func ignoreCommentEvent(ctx context.Context, deliveryID string, body []byte) (int, []byte) {
+	//https://docs.github.com/en/developers/webhooks-and-events/webhooks/webhook-events-and-payloads#issue_comment
+
+	req := ignoreRequest{
+		InstallationID: installationID(body),
+		Owner:          gjson.GetBytes(body, "repository.owner.login").String(),
+		Repo:           gjson.GetBytes(body, "repository.name").String(),
+		Number:         int(gjson.GetBytes(body, "issue.number").Int()),
+		User:           gjson.GetBytes(body, "comment.user.login").String(),
+	}
+	req.Fingerprint, req.Note = parseIgnoreCommand(gjson.GetBytes(body, "comment.body").String())
+
+	logger.CreateBreadcrumb("ignoreCommentEvent", fmt.Sprintf("number=%d,repo=%s/%s,fingerprint=%s,user=%s", req.Number, req.Owner, req.Repo, req.Fingerprint, req.User))
+
+	ignoreWarning(req)
+	return 200, nil
+}
+
+// parseIgnoreCommand returns the fingerprint and note of an ignore command:
+// /brakeman ignore <fingerprint> <note>
+func parseIgnoreCommand(comment string) (fingerprint, note string) {
+	args := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(comment), ignoreCommand))
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	return fields[0], strings.TrimSpace(strings.TrimPrefix(args, fields[0]))
+}
+
+// ignoreWarning adds a warning to the brakeman.ignore file on the branch of a
+// pull request, so the next scans skip it. Only users with write access to the
+// repository may ignore warnings. The warning is looked up in the last scan of
+// the head of the pull request. The outcome is reported with a comment on the
+// pull request.
+func ignoreWarning(req ignoreRequest) {
+	gh := installations.Client(req.InstallationID)
+
+	msg, err := commitIgnored(gh, req)
+	if err != nil {
+		logger.Error(err)
+		msg = fmt.Sprintf("@%s the warning could not be ignored: %s", req.User, err)
+	}
+
+	if err := gh.PostCommentToGit(req.Owner, req.Repo, fmt.Sprint(req.Number), msg); err != nil {
+		logger.Error(err)
+	}
+}
+
+// commitIgnored commits the updated brakeman.ignore file and returns the message for the user
+func commitIgnored(gh *github.Client, req ignoreRequest) (string, error) {
+	if len(req.Fingerprint) < minFingerprintPrefix {
+		return "", fmt.Errorf("give the fingerprint of the warning, at least its first %d characters: `%s <fingerprint> <note>`", minFingerprintPrefix, ignoreCommand)
+	}
+	if req.Note == "" {
+		return "", fmt.Errorf("give a note why the warning can be ignored: `%s <fingerprint> <note>`", ignoreCommand)
+	}
+
+	perm, _, err := gh.GetCollaboratorPermission(req.Owner, req.Repo, req.User)
+	if err != nil {
+		return "", fmt.Errorf("couldn't check the permission of @%s: %s", req.User, err)
+	}
+	if perm.Permission == nil || (*perm.Permission != "admin" && *perm.Permission != "write") {
+		return "", fmt.Errorf("only users with write access to the repository may ignore warnings")
+	}
+
+	pr, _, err := gh.GetPullRequest(req.Owner, req.Repo, req.Number)
+	if err != nil {
+		return "", fmt.Errorf("couldn't fetch the pull request: %s", err)
+	}
+	if pr.State == nil || pr.Head == nil || pr.Head.SHA == nil || pr.Head.Ref == nil || pr.Base == nil || pr.Base.Repo == nil {
+		return "", fmt.Errorf("GitHub returned an incomplete pull request")
+	}
+	// the App can't push to forks, and shouldn't change code it doesn't own
+	if pr.Head.Repo == nil || pr.Head.Repo.FullName == nil || pr.Base.Repo.FullName == nil || *pr.Head.Repo.FullName != *pr.Base.Repo.FullName {
+		return "", fmt.Errorf("warnings of pull requests from forks have to be ignored in the fork")
+	}
+	if *pr.State != "open" {
+		return "", fmt.Errorf("the pull request is not open")
+	}
+
+	rec, err := history.LastScan(req.Owner, req.Repo, *pr.Head.SHA)
+	if err != nil {
+		return "", err
+	}
+	if rec == nil {
+		return "", fmt.Errorf("the head of the pull request wasn't scanned yet")
+	}
+
+	w, err := findWarning(rec.Warnings, req.Fingerprint)
+	if err != nil {
+		return "", err
+	}
+
+	// brakeman reads the ignore file of the app, with paths relative to the app
+	cfg, cfgErr := loadRepoConfig(gh, req.Owner, req.Repo, *pr.Head.SHA)
+	if cfgErr != nil {
+		logger.Error(cfgErr)
+	}
+	// every app has its own ignore file, scans from before the app was recorded used the configured app
+	app := w.App
+	if app == "" {
+		app = cfg.AppPath
+	}
+	if app != "" {
+		w.File = strings.TrimPrefix(w.File, path.Clean(app)+"/")
+	}
+	ignorePath := path.Join(app, scanner.IgnoreFile)
+
+	// read the file from the branch, so it is replaced only when no one changed it since
+	var data []byte
+	update := &github.ContentUpdate{Branch: *pr.Head.Ref}
+	content, resp, err := gh.GetContents(req.Owner, req.Repo, ignorePath, *pr.Head.Ref)
+	if err != nil && (resp == nil || resp.Message != "Not Found") {
+		return "", fmt.Errorf("couldn't fetch %s: %s", ignorePath, err)
+	}
+	if err == nil {
+		if content.Content == nil || content.SHA == nil {
+			return "", fmt.Errorf("%s is not a file", ignorePath)
+		}
+		if data, err = base64.StdEncoding.DecodeString(*content.Content); err != nil {
+			return "", fmt.Errorf("couldn't decode %s: %s", ignorePath, err)
+		}
+		update.SHA = *content.SHA
+	}
+
+	data, added, err := scanner.AddIgnored(data, w, req.Note, rec.BrakemanVersion)
+	if err != nil {
+		return "", err
+	}
+	if !added {
+		return fmt.Sprintf("@%s the warning `%s` is in `%s` already.", req.User, w.FingerPrint, ignorePath), nil
+	}
+
+	update.Content = base64.StdEncoding.EncodeToString(data)
+	update.Message = fmt.Sprintf("Ignore brakeman warning %s\n\n%s: %s", w.FingerPrint, w.WarningType, req.Note)
+	result, _, err := gh.UpdateContents(req.Owner, req.Repo, ignorePath, update)
+	if err != nil {
+		return "", fmt.Errorf("couldn't commit %s: %s", ignorePath, err)
+	}
+
+	logger.CreateBreadcrumb("commitIgnored", fmt.Sprintf("repo=%s/%s,branch=%s,fingerprint=%s,user=%s", req.Owner, req.Repo, *pr.Head.Ref, w.FingerPrint, req.User))
+	msg := fmt.Sprintf("@%s added the %s warning `%s` in `%s` to `%s`", req.User, w.WarningType, w.FingerPrint, w.File, ignorePath)
+	if result.Commit != nil && result.Commit.SHA != nil {
+		msg += " in " + *result.Commit.SHA
+	}
+	return msg + ".", nil
+}
+
+// findWarning returns the warning whose fingerprint starts with the given prefix
+func findWarning(warnings []scanner.WarningInfo, prefix string) (w scanner.WarningInfo, err error) {
+	found := 0
+	for _, warning := range warnings {
+		if prefix != "" && strings.HasPrefix(warning.FingerPrint, prefix) {
+			w = warning
+			found++
+		}
+	}
+	switch {
+	case found == 0:
+		return w, fmt.Errorf("there is no warning with the fingerprint `%s` in the last scan", prefix)
+	case found > 1:
+		return w, fmt.Errorf("more than one warning has a fingerprint starting with `%s`, give more of the fingerprint", prefix)
+	}
+	return w, nil
+}
+
+// ignoreKey identifies the pull request an ignore request is about, so it is
+// cancelled with the scans when the pull request is closed
+func ignoreKey(event string, body []byte) string {
+	number := gjson.GetBytes(body, "issue.number").String()
+	if event == "check_run" {
+		number = gjson.GetBytes(body, "check_run.pull_requests.0.number").String()
+	}
+	return fmt.Sprintf("%s/%s#%s",
+		gjson.GetBytes(body, "repository.owner.login").String(),
+		gjson.GetBytes(body, "repository.name").String(),
+		number)
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ci-brakeman/scanner"
+	"github.com/ci-brakeman/store"
+)
+
+func TestFindWarning(t *testing.T) {
+	warnings := []scanner.WarningInfo{
+		{FingerPrint: "abcdef0123456789"},
+		{FingerPrint: "abcdef9876543210"},
+		{FingerPrint: "0123456789abcdef"},
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   string
+		err    string
+	}{
+		{name: "full fingerprint", prefix: "0123456789abcdef", want: "0123456789abcdef"},
+		{name: "unique prefix", prefix: "abcdef01", want: "abcdef0123456789"},
+		{name: "ambiguous prefix", prefix: "abcdef", err: "more than one warning"},
+		{name: "unknown fingerprint", prefix: "ffffffff", err: "no warning"},
+		{name: "empty", prefix: "", err: "no warning"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := findWarning(warnings, tt.prefix)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("findWarning() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.FingerPrint != tt.want {
+				t.Errorf("findWarning() = %q, want %q", w.FingerPrint, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIgnoreCommand(t *testing.T) {
+	tests := []struct {
+		comment     string
+		fingerprint string
+		note        string
+	}{
+		{comment: "/brakeman ignore"},
+		{comment: "/brakeman ignore abcdef01", fingerprint: "abcdef01"},
+		{comment: "  /brakeman ignore abcdef01   only admins reach this\n", fingerprint: "abcdef01", note: "only admins reach this"},
+		{comment: "/brakeman ignore abcdef01 the input\nis validated", fingerprint: "abcdef01", note: "the input\nis validated"},
+	}
+
+	for _, tt := range tests {
+		fingerprint, note := parseIgnoreCommand(tt.comment)
+		if fingerprint != tt.fingerprint || note != tt.note {
+			t.Errorf("parseIgnoreCommand(%q) = %q, %q, want %q, %q", tt.comment, fingerprint, note, tt.fingerprint, tt.note)
+		}
+	}
+}
+
+func TestIgnorePrompt(t *testing.T) {
+	req := ignoreRequest{User: "octocat", Fingerprint: "abcdef0123456"}
+	rec := &store.Record{Warnings: []scanner.WarningInfo{{FingerPrint: "abcdef0123456789", WarningType: "SQL Injection", File: "app/models/user.rb"}}}
+
+	msg := ignorePrompt(req, rec)
+	if !strings.Contains(msg, ignoreCommand+" abcdef0123456789 <note>") {
+		t.Errorf("ignorePrompt() = %q, want the command with the full fingerprint", msg)
+	}
+	if msg := ignorePrompt(req, nil); !strings.Contains(msg, "not known anymore") {
+		t.Errorf("ignorePrompt() without a scan = %q", msg)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - merge
+// Contains the logic to check out the commits of a pull request that get scanned, its head and merge commit
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/ci-brakeman/config"
+	"github.com/ci-brakeman/github"
+	"github.com/ci-brakeman/logger"
+	"github.com/ci-brakeman/scanner"
+	"github.com/ci-brakeman/store"
+)
+
+// GitHub computes in the background whether a pull request can be merged,
+// it is asked again a few times until it knows
+const mergeableAttempts = 3
+const mergeableWait = 2 * time.Second
+
+// scanTarget is a commit that is checked out to be scanned
+type scanTarget struct {
+	// Kind is head or merge
+	Kind string
+	SHA  string
+	// Dir is where the commit is checked out
+	Dir string
+	// BaseSHA is the base a merge commit was created on, empty for the head
+	BaseSHA string
+}
+
+// checkoutTargets checks out the commits to scan into sub-directories of dir,
+// the head is always first. The merge commit of a pull request is only
+// scanned when the pull request can be merged, otherwise the head is scanned
+// and a note says why.
+func checkoutTargets(ctx context.Context, gh *github.Client, req scanRequest, target, dir string) (targets []scanTarget, notes []string, err error) {
+	scanHead := !req.isPullRequest() || target != config.TargetMerge
+
+	if req.isPullRequest() && target != config.TargetHead {
+		mergeDir := filepath.Join(dir, "merge")
+		sha, baseSHA, reason := mergeCommit(ctx, gh, req)
+		if sha != "" {
+			if sha, err = gh.CloneGitRepository(ctx, req.RepoURL, req.mergeRef(), sha, mergeDir); err != nil {
+				logger.Error(err)
+				reason = "it could not be fetched"
+			}
+		}
+		if reason == "" {
+			targets = append(targets, scanTarget{Kind: config.TargetMerge, SHA: sha, Dir: mergeDir, BaseSHA: baseSHA})
+		} else {
+			note := fmt.Sprintf("The merge commit of the pull request was not scanned, %s.", reason)
+			if !scanHead {
+				note += " The head was scanned instead."
+			}
+			notes = append(notes, note)
+			scanHead = true
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	if scanHead {
+		headDir := filepath.Join(dir, "head")
+		sha, err := gh.CloneGitRepository(ctx, req.RepoURL, req.gitRef(), req.HeadSHA, headDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		targets = append([]scanTarget{{Kind: config.TargetHead, SHA: sha, Dir: headDir}}, targets...)
+	}
+	return targets, notes, nil
+}
+
+// recordTargets notes the commits that are scanned in the record of the scan.
+// A merge commit is compared with the base it was created on, which may be
+// newer than the base of the event.
+func recordTargets(rec *store.Record, targets []scanTarget) {
+	for _, t := range targets {
+		if t.Kind == config.TargetMerge {
+			rec.MergeSHA = t.SHA
+			rec.BaseSHA = t.BaseSHA
+		} else {
+			rec.ScannedSHA = t.SHA
+		}
+	}
+}
+
+// mergeCommit returns the commit GitHub created by merging the head of the
+// pull request into its current base along with that base, or why there is none
+func mergeCommit(ctx context.Context, gh *github.Client, req scanRequest) (sha, baseSHA, reason string) {
+	number, _ := strconv.Atoi(req.Number)
+
+	var pr *github.PullRequest
+	for attempt := 1; ; attempt++ {
+		var err error
+		if pr, _, err = gh.GetPullRequest(req.Owner, req.Repo, number); err != nil {
+			logger.Error(err)
+			return "", "", "the pull request could not be fetched"
+		}
+		if pr.Mergeable != nil || attempt == mergeableAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return "", "", "the scan was cancelled"
+		case <-time.After(mergeableWait):
+		}
+	}
+
+	switch {
+	case pr.Head == nil || pr.Head.SHA == nil || *pr.Head.SHA != req.HeadSHA:
+		return "", "", "the pull request was updated since"
+	case pr.Base == nil || pr.Base.SHA == nil || *pr.Base.SHA == "":
+		return "", "", "GitHub did not return its base"
+	case pr.Mergeable == nil:
+		return "", "", "GitHub did not determine in time whether it can be merged"
+	case !*pr.Mergeable:
+		return "", "", "it has conflicts with the base branch"
+	case pr.MergeCommitSHA == nil || *pr.MergeCommitSHA == "":
+		return "", "", "GitHub did not create one"
+	}
+	return *pr.MergeCommitSHA, *pr.Base.SHA, ""
+}
+
+// scanTargets scans the checked out commits and combines their findings. The
+// warnings of the head come first, followed by those only found in the merge
+// commit.
+func scanTargets(ctx context.Context, targets []scanTarget, opts scanner.Options) (finding scanner.Findings, errorBit int, err error) {
+	seen := map[string]bool{}
+	for i, t := range targets {
+		f, bit, err := scanner.ScanFolder(ctx, t.Dir, opts)
+		if err != nil || bit != 0 {
+			return f, bit, err
+		}
+		if i == 0 {
+			finding = f
+		} else {
+			for _, w := range f.Warnings {
+				if !seen[w.FingerPrint] {
+					finding.Warnings = append(finding.Warnings, w)
+				}
+			}
+		}
+		for _, w := range finding.Warnings {
+			seen[w.FingerPrint] = true
+		}
+	}
+	return finding, 0, nil
+}
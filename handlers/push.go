@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - push
+// Contains the logic to scan pushes to the default and protected branches
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ci-brakeman/logger"
+	"github.com/tidwall/gjson"
+)
+
+// isBranchPush reports whether a push event added commits to a branch.
+// Pushes of tags and deleted branches have nothing to scan.
+func isBranchPush(body []byte) bool {
+	return strings.HasPrefix(gjson.GetBytes(body, "ref").String(), "refs/heads/") &&
+		!gjson.GetBytes(body, "deleted").Bool()
+}
+
+// pushKey identifies the branch a push event is about
+func pushKey(body []byte) string {
+	return fmt.Sprintf("%s/%s@%s",
+		gjson.GetBytes(body, "repository.owner.login").String(),
+		gjson.GetBytes(body, "repository.name").String(),
+		strings.TrimPrefix(gjson.GetBytes(body, "ref").String(), "refs/heads/"))
+}
+
+// handler for push. Only pushes to the default branch or a protected branch are
+// scanned, the result becomes the baseline of the branch.
+func pushEvent(ctx context.Context, deliveryID string, body []byte) (int, []byte) {
+	//https://docs.github.com/en/developers/webhooks-and-events/webhooks/webhook-events-and-payloads#push
+
+	req := scanRequest{
+		Event:          "push",
+		DeliveryID:     deliveryID,
+		InstallationID: installationID(body),
+		Repo:           gjson.GetBytes(body, "repository.name").String(),
+		Owner:          gjson.GetBytes(body, "repository.owner.login").String(),
+		HeadSHA:        gjson.GetBytes(body, "after").String(),
+		HeadRef:        strings.TrimPrefix(gjson.GetBytes(body, "ref").String(), "refs/heads/"),
+		RepoURL:        gjson.GetBytes(body, "repository.html_url").String(),
+	}
+	defaultBranch := gjson.GetBytes(body, "repository.default_branch").String()
+	pusher := gjson.GetBytes(body, "pusher.name").String()
+
+	logger.CreateBreadcrumb("pushEvent", fmt.Sprintf("ref=%s,repo=%s/%s,pusher=%s", req.HeadRef, req.Owner, req.Repo, pusher))
+
+	scanned, err := isScannedBranch(req, defaultBranch)
+	if err != nil {
+		logger.Error(err)
+		return 500, nil
+	}
+	if !scanned {
+		logger.CreateBreadcrumb("pushEvent", fmt.Sprintf("skipping push to unprotected branch %s", req.key()))
+		return 200, nil
+	}
+
+	processScan(ctx, req)
+	return 200, nil
+}
+
+// isScannedBranch reports whether commits of the branch of req are scanned on
+// their own. Only the default branch and protected branches are, their scans
+// become the baseline of the branch.
+func isScannedBranch(req scanRequest, defaultBranch string) (bool, error) {
+	if req.HeadRef == defaultBranch {
+		return true, nil
+	}
+	return isProtected(req)
+}
+
+// isProtected reports whether the pushed branch is protected
+func isProtected(req scanRequest) (bool, error) {
+	gh := installations.Client(req.InstallationID)
+	branch, _, err := gh.GetBranch(req.Owner, req.Repo, req.HeadRef)
+	if err != nil {
+		return false, err
+	}
+	return branch.Protected != nil && *branch.Protected, nil
+}
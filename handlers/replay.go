@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - replay
+// Contains the logic to detect duplicate and replayed webhook deliveries
+package handlers
+
+import (
+	"time"
+
+	"github.com/ci-brakeman/ledger"
+	"github.com/tidwall/gjson"
+)
+
+// deliveries holds the GUIDs of the deliveries that were processed
+var deliveries = ledger.New(24 * time.Hour)
+
+// maxPayloadAge is how old the timestamps in a payload may be, zero disables the check
+var maxPayloadAge time.Duration
+
+// payloadTimestamps are the fields of each event holding when the event
+// happened. Only fields that change with every event are used, the payloads
+// of other events can't be told apart from a replay by their age and are not
+// checked. Check suite and check run payloads only hold when the checks ran,
+// which can be long before they are rerequested.
+var payloadTimestamps = map[string][]string{
+	"pull_request":  {"pull_request.updated_at"},
+	"issue_comment": {"comment.updated_at"},
+	// in push payloads pushed_at is the time of the push itself, while the
+	// timestamp of the head commit can be much older
+	"push": {"repository.pushed_at"},
+}
+
+// SetupDeliveries sets how long delivery GUIDs are remembered and how old
+// the payload of a delivery may be
+func SetupDeliveries(ttl, maxAge time.Duration) {
+	deliveries = ledger.New(ttl)
+	maxPayloadAge = maxAge
+}
+
+// payloadTime returns the most recent timestamp found in the payload of an
+// event, or the zero time when the payload holds none of the timestamps of the event
+func payloadTime(event string, body []byte) (latest time.Time) {
+	for _, field := range payloadTimestamps[event] {
+		v := gjson.GetBytes(body, field)
+		var t time.Time
+		switch v.Type {
+		case gjson.Number:
+			// push payloads hold unix timestamps
+			t = time.Unix(v.Int(), 0)
+		case gjson.String:
+			var err error
+			if t, err = time.Parse(time.RFC3339, v.String()); err != nil {
+				continue
+			}
+		default:
+			continue
+		}
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return
+}
+
+// isReplay reports whether the payload of an event is too old to be a fresh
+// delivery. A signed payload that was captured somewhere can't be replayed
+// after that. Events without a timestamp are only protected by the delivery GUID.
+func isReplay(event string, body []byte) bool {
+	if maxPayloadAge <= 0 {
+		return false
+	}
+	t := payloadTime(event, body)
+	return !t.IsZero() && time.Since(t) > maxPayloadAge
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPayloadTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		body  string
+		want  time.Time
+	}{
+		{
+			name:  "pull request",
+			event: "pull_request",
+			body:  `{"pull_request":{"updated_at":"2021-03-04T05:06:07Z"},"repository":{"pushed_at":1700000000}}`,
+			want:  time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		},
+		{
+			name:  "issue comment",
+			event: "issue_comment",
+			body:  `{"comment":{"updated_at":"2021-03-04T05:06:07Z"}}`,
+			want:  time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		},
+		{
+			name:  "push with a unix timestamp",
+			event: "push",
+			body:  `{"repository":{"pushed_at":1614834367}}`,
+			want:  time.Unix(1614834367, 0),
+		},
+		{
+			name:  "check run ignores the repository push time",
+			event: "check_run",
+			body:  `{"check_run":{"completed_at":"2021-03-04T05:06:07Z"},"repository":{"pushed_at":1614834367}}`,
+		},
+		{
+			name:  "invalid timestamp",
+			event: "pull_request",
+			body:  `{"pull_request":{"updated_at":"yesterday"}}`,
+		},
+		{
+			name:  "missing timestamp",
+			event: "pull_request",
+			body:  `{}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := payloadTime(tt.event, []byte(tt.body)); !got.Equal(tt.want) {
+				t.Errorf("payloadTime() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsReplay(t *testing.T) {
+	defer func(age time.Duration) { maxPayloadAge = age }(maxPayloadAge)
+
+	old := time.Now().Add(-time.Hour)
+	fresh := time.Now().Add(-time.Minute)
+	pullRequest := func(t time.Time) string {
+		return fmt.Sprintf(`{"pull_request":{"updated_at":%q}}`, t.UTC().Format(time.RFC3339))
+	}
+
+	tests := []struct {
+		name   string
+		maxAge time.Duration
+		event  string
+		body   string
+		replay bool
+	}{
+		{name: "fresh pull request", maxAge: 5 * time.Minute, event: "pull_request", body: pullRequest(fresh)},
+		{name: "old pull request", maxAge: 5 * time.Minute, event: "pull_request", body: pullRequest(old), replay: true},
+		{name: "old pull request without a maximum age", event: "pull_request", body: pullRequest(old)},
+		{name: "old push", maxAge: 5 * time.Minute, event: "push", body: fmt.Sprintf(`{"repository":{"pushed_at":%d}}`, old.Unix()), replay: true},
+		{
+			name:   "check run of a repository without a recent push",
+			maxAge: 5 * time.Minute,
+			event:  "check_run",
+			body:   fmt.Sprintf(`{"action":"rerequested","repository":{"pushed_at":%d}}`, old.Unix()),
+		},
+		{
+			name:   "check suite of a repository without a recent push",
+			maxAge: 5 * time.Minute,
+			event:  "check_suite",
+			body:   fmt.Sprintf(`{"action":"rerequested","repository":{"pushed_at":%d}}`, old.Unix()),
+		},
+		{name: "payload without a timestamp", maxAge: 5 * time.Minute, event: "pull_request", body: `{}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxPayloadAge = tt.maxAge
+			if got := isReplay(tt.event, []byte(tt.body)); got != tt.replay {
+				t.Errorf("isReplay() = %v, want %v", got, tt.replay)
+			}
+		})
+	}
+}
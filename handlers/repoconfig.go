@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - repoconfig
+// Contains the logic to read the configuration file of a scanned repository
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/ci-brakeman/config"
+	"github.com/ci-brakeman/github"
+	"github.com/ci-brakeman/logger"
+	"github.com/ci-brakeman/scanner"
+)
+
+// loadRepoConfig reads the configuration file of a repository at the given
+// commit. Repositories without a configuration file get the defaults, a file
+// that can't be used also gets the defaults along with the error.
+func loadRepoConfig(gh *github.Client, owner, repo, ref string) (*config.Config, error) {
+	defaults := config.Default(scanPolicy)
+
+	content, resp, err := gh.GetContents(owner, repo, config.FileName, ref)
+	if err != nil {
+		if resp != nil && resp.Message == "Not Found" {
+			return defaults, nil
+		}
+		return defaults, fmt.Errorf("Couldn't fetch %s: %s", config.FileName, err)
+	}
+	if content.Content == nil {
+		return defaults, fmt.Errorf("%s is not a file", config.FileName)
+	}
+
+	// the contents API always base64 encodes the file
+	data, err := base64.StdEncoding.DecodeString(*content.Content)
+	if err != nil {
+		return defaults, fmt.Errorf("Couldn't decode %s: %s", config.FileName, err)
+	}
+
+	cfg, err := config.Parse(data, defaults)
+	if err != nil {
+		return defaults, err
+	}
+	logger.CreateBreadcrumb("loadRepoConfig", fmt.Sprintf("repo=%s/%s,ref=%s", owner, repo, ref))
+	return cfg, nil
+}
+
+// loadScanConfig reads the configuration a scan runs with. Pull requests get
+// the settings deciding what passes from their base, and pull requests from
+// forks get the whole configuration of the base.
+func loadScanConfig(gh *github.Client, req scanRequest) (*config.Config, error) {
+	if !req.isPullRequest() {
+		return loadRepoConfig(gh, req.Owner, req.Repo, req.HeadSHA)
+	}
+	base, baseErr := loadRepoConfig(gh, req.Owner, req.Repo, req.BaseSHA)
+	if req.Fork {
+		return base, baseErr
+	}
+
+	head, err := loadRepoConfig(gh, req.Owner, req.Repo, req.HeadSHA)
+	if err == nil {
+		err = baseErr
+	}
+	return head.WithProtected(base), err
+}
+
+// scanOptions returns how the repository wants to be scanned
+func scanOptions(cfg *config.Config) scanner.Options {
+	return scanner.Options{
+		AppPaths:   cfg.Apps(),
+		SkipFiles:  cfg.ExcludePaths,
+		ExtraFlags: cfg.BrakemanFlags,
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - rerun
+// Contains the logic to run a scan again when "Re-run" is clicked on the check
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/ci-brakeman/github"
+	"github.com/ci-brakeman/logger"
+	"github.com/tidwall/gjson"
+)
+
+// isRerequest reports whether a check_suite or check_run event asks for our check to run again
+func isRerequest(event string, body []byte) bool {
+	if gjson.GetBytes(body, "action").String() != "rerequested" {
+		return false
+	}
+	if event == "check_run" {
+		return gjson.GetBytes(body, "check_run.name").String() == github.CheckRunName
+	}
+	return true
+}
+
+// rerunKey identifies what a rerequested check is about, the same way the scan jobs do
+func rerunKey(event string, body []byte) string {
+	owner := gjson.GetBytes(body, "repository.owner.login").String()
+	repo := gjson.GetBytes(body, "repository.name").String()
+	if number := gjson.GetBytes(body, event+".pull_requests.0.number").String(); number != "" {
+		return fmt.Sprintf("%s/%s#%s", owner, repo, number)
+	}
+	return fmt.Sprintf("%s/%s@%s", owner, repo, rerunBranch(event, body))
+}
+
+// rerunBranch returns the branch of the commit a rerequested check belongs to
+func rerunBranch(event string, body []byte) string {
+	if event == "check_run" {
+		return gjson.GetBytes(body, "check_run.check_suite.head_branch").String()
+	}
+	return gjson.GetBytes(body, "check_suite.head_branch").String()
+}
+
+// handler for check_suite and check_run rerequested. Finds the pull request of
+// the commit and scans it again, commits without a pull request are scanned
+// like a push when they are on a branch pushes are scanned on.
+func rerunEvent(ctx context.Context, deliveryID, event string, body []byte) (int, []byte) {
+	//https://docs.github.com/en/developers/webhooks-and-events/webhooks/webhook-events-and-payloads#check_suite
+	//https://docs.github.com/en/developers/webhooks-and-events/webhooks/webhook-events-and-payloads#check_run
+
+	owner := gjson.GetBytes(body, "repository.owner.login").String()
+	repo := gjson.GetBytes(body, "repository.name").String()
+	headSHA := gjson.GetBytes(body, event+".head_sha").String()
+	instID := installationID(body)
+	sender := gjson.GetBytes(body, "sender.login").String()
+
+	logger.CreateBreadcrumb("rerunEvent", fmt.Sprintf("event=%s,repo=%s/%s,sha=%s,sender=%s", event, owner, repo, headSHA, sender))
+
+	gh := installations.Client(instID)
+
+	pr, err := findPullRequest(gh, owner, repo, headSHA, int(gjson.GetBytes(body, event+".pull_requests.0.number").Int()))
+	if err != nil {
+		logger.Error(err)
+		return 500, nil
+	}
+
+	var req scanRequest
+	if pr != nil {
+		req = pullRequestScan(pr)
+		// rerequesting a check doesn't approve the scan of a fork
+		if req.Fork && !forkAllowed(false) {
+			req.HeadSHA = headSHA
+			skipFork(gh, req, pullRequestLabels(pr))
+			return 200, nil
+		}
+	} else {
+		req = scanRequest{
+			Event:          "push",
+			InstallationID: instID,
+			Owner:          owner,
+			Repo:           repo,
+			HeadRef:        rerunBranch(event, body),
+			RepoURL:        gjson.GetBytes(body, "repository.html_url").String(),
+		}
+		// the scan becomes the baseline of the branch, like the scan of a push
+		scanned, err := isScannedBranch(req, gjson.GetBytes(body, "repository.default_branch").String())
+		if err != nil {
+			logger.Error(err)
+			return 500, nil
+		}
+		if !scanned {
+			logger.CreateBreadcrumb("rerunEvent", fmt.Sprintf("skipping commit %s of unprotected branch %s", headSHA, req.key()))
+			return 200, nil
+		}
+	}
+	req.DeliveryID = deliveryID
+	req.InstallationID = instID
+	// scan the commit the check was rerequested for, even when the branch moved on since
+	req.HeadSHA = headSHA
+
+	processScan(ctx, req)
+	return 200, nil
+}
+
+// findPullRequest returns the pull request with the given number, or when the
+// number is not known the open pull request whose head is the given commit.
+// Returns nil when the commit doesn't belong to a pull request.
+func findPullRequest(gh *github.Client, owner, repo, headSHA string, number int) (*github.PullRequest, error) {
+	if number == 0 {
+		// pull requests from forks are not listed in the check payloads
+		prs, _, err := gh.GetCommitPullRequests(owner, repo, headSHA)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range prs {
+			if pr.State != nil && *pr.State == "open" && pr.Head != nil && pr.Head.SHA != nil && *pr.Head.SHA == headSHA {
+				number = *pr.Number
+				break
+			}
+		}
+		if number == 0 {
+			return nil, nil
+		}
+	}
+
+	pr, _, err := gh.GetPullRequest(owner, repo, number)
+	return pr, err
+}
+
+// pullRequestScan returns the scan request for a pull request fetched from the
+// API. Like pullReqEvent it scans the pull request in the base repository.
+func pullRequestScan(pr *github.PullRequest) scanRequest {
+	return scanRequest{
+		Event:       "pull_request",
+		Number:      strconv.Itoa(*pr.Number),
+		Repo:        *pr.Base.Repo.Name,
+		Owner:       *pr.Base.Repo.Owner.Login,
+		HeadSHA:     *pr.Head.SHA,
+		HeadRef:     *pr.Head.Ref,
+		RepoURL:     *pr.Base.Repo.HTMLURL,
+		BaseSHA:     *pr.Base.SHA,
+		BaseRef:     *pr.Base.Ref,
+		BaseRepoURL: *pr.Base.Repo.HTMLURL,
+		// a deleted head repository was a fork
+		Fork: pr.Head.Repo == nil || pr.Head.Repo.FullName == nil || *pr.Head.Repo.FullName != *pr.Base.Repo.FullName,
+	}
+}
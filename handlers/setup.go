@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - setup
+// Contains the setup of the GitHub client, scan queue, scan actions, forks, workspaces, history and policy the handlers use
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/ci-brakeman/github"
+	"github.com/ci-brakeman/policy"
+	"github.com/ci-brakeman/queue"
+	"github.com/ci-brakeman/store"
+	"github.com/ci-brakeman/workspace"
+)
+
+// installations hands out the GitHub clients acting on behalf of the installations of the App
+var installations *github.Installations
+
+// botLogin is the login the GitHub App comments with
+var botLogin string
+
+// SetupGitHub sets the installations of the GitHub App the events come from,
+// and the slug of the App that names the user it comments as
+func SetupGitHub(i *github.Installations, appSlug string) {
+	installations = i
+	botLogin = appSlug + "[bot]"
+}
+
+// jobQueue holds the scans waiting to be processed
+var jobQueue *queue.Queue
+
+// SetupQueue creates the scan queue and starts its workers. Has to be called
+// before the Catcher handler receives any events.
+func SetupQueue(workers, depth int) {
+	jobQueue = queue.New(workers, depth)
+	jobQueue.Start()
+}
+
+// scanActions are the pull_request actions that start a scan
+var scanActions = map[string]bool{
+	"opened":           true,
+	"synchronize":      true,
+	"reopened":         true,
+	"ready_for_review": true,
+}
+
+// SetupScanActions sets the pull_request actions that start a scan
+func SetupScanActions(actions []string) {
+	scanActions = make(map[string]bool, len(actions))
+	for _, a := range actions {
+		scanActions[a] = true
+	}
+}
+
+// forkPolicy decides whether pull requests from forks are scanned, see ForkScan
+var forkPolicy = ForkScan
+
+// forkLabel is the label approving the scan of a pull request from a fork
+var forkLabel = "safe to scan"
+
+// SetupForks sets how pull requests from forks are dealt with, and the label
+// approving their scan when the policy is ForkApproval
+func SetupForks(policy, label string) error {
+	switch policy {
+	case ForkScan, ForkSkip, ForkApproval:
+	default:
+		return fmt.Errorf("unknown fork policy %q, use %s, %s or %s", policy, ForkScan, ForkSkip, ForkApproval)
+	}
+	forkPolicy = policy
+	if label != "" {
+		forkLabel = label
+	}
+	return nil
+}
+
+// workspaces hands out the working directories of the scan jobs
+var workspaces *workspace.Manager
+
+// SetupWorkspaces creates the workspace manager for the given root directory
+// and removes the workspaces left behind by jobs of a previous run that crashed
+func SetupWorkspaces(root string) (err error) {
+	if workspaces, err = workspace.NewManager(root); err != nil {
+		return
+	}
+	return workspaces.Sweep()
+}
+
+// history keeps the records of all scans
+var history *store.Store
+
+// SetupHistory opens the scan history kept in the given directory, keeping
+// maxRecords records per repository, 0 for all of them
+func SetupHistory(dir string, maxRecords int) (err error) {
+	history, err = store.Open(dir, maxRecords)
+	return
+}
+
+// scanPolicy decides the conclusion of the check runs
+var scanPolicy = policy.Default()
+
+// SetupPolicy sets the policy deciding the conclusion of the check runs
+func SetupPolicy(p policy.Policy) {
+	scanPolicy = p
+}
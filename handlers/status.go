@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package handlers - status
+// Contains the endpoint reporting the state of the scan queue, workspaces, deliveries and rate limits
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ci-brakeman/github"
+	"github.com/ci-brakeman/logger"
+	"github.com/ci-brakeman/queue"
+)
+
+// statusToken is the bearer token that shows the details of the state, empty
+// to only ever show the counts
+var statusToken string
+
+// SetupStatus sets the token that shows the details of the state on the status endpoint
+func SetupStatus(token string) {
+	statusToken = token
+}
+
+// Status reports the current state of the service as JSON. The jobs,
+// workspaces and rate limits name repositories and installations, they are
+// only shown with the status token. Everyone else gets the counts.
+func Status(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		Queue      queue.Stats            `json:"queue"`
+		Workspaces map[string]string      `json:"workspaces,omitempty"`
+		InUse      int                    `json:"workspaces_in_use"`
+		Deliveries int                    `json:"deliveries"`
+		RateLimits *github.RateLimitStats `json:"rate_limits,omitempty"`
+	}{
+		Queue:      jobQueue.Stats(),
+		Workspaces: workspaces.Owners(),
+		Deliveries: deliveries.Len(),
+	}
+	status.InUse = len(status.Workspaces)
+	if stats, ok := installations.RateLimitStats(); ok {
+		status.RateLimits = &stats
+	}
+
+	if !statusAuthorized(r) {
+		status.Queue.Jobs = nil
+		status.Workspaces = nil
+		if status.RateLimits != nil {
+			status.RateLimits.Limits = nil
+		}
+	}
+
+	body, err := json.Marshal(status)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error!"))
+		logger.Error(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	if _, err := w.Write(body); err != nil {
+		logger.Error(err)
+	}
+}
+
+// statusAuthorized reports whether a request to the status endpoint carries the status token
+func statusAuthorized(r *http.Request) bool {
+	if statusToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(statusToken)) == 1
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package ledger - ledger
+// Contains a ledger of the webhook deliveries that were already processed
+package ledger
+
+import (
+	"sync"
+	"time"
+)
+
+// Ledger remembers delivery GUIDs for a limited time, so retries and
+// redeliveries of the same webhook are only processed once
+type Ledger struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// New creates a ledger that remembers deliveries for the given time
+func New(ttl time.Duration) *Ledger {
+	return &Ledger{
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// Claim records a delivery and reports whether it is new. It returns false
+// when the delivery was claimed before and has not expired yet.
+func (l *Ledger) Claim(guid string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	if _, ok := l.seen[guid]; ok {
+		return false
+	}
+	l.seen[guid] = now.Add(l.ttl)
+	return true
+}
+
+// Release forgets a delivery, so a redelivery of it is processed again.
+// Used when a delivery could not be processed, e.g. because the queue was full.
+func (l *Ledger) Release(guid string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.seen, guid)
+}
+
+// Len returns the number of deliveries in the ledger
+func (l *Ledger) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(time.Now())
+	return len(l.seen)
+}
+
+// prune removes the expired deliveries, the lock has to be held
+func (l *Ledger) prune(now time.Time) {
+	for guid, expiresAt := range l.seen {
+		if now.After(expiresAt) {
+			delete(l.seen, guid)
+		}
+	}
+}
@@ -25,7 +25,7 @@ func Debug(s string) {
 
 // Debugf write to the console/logstream
 func Debugf(s string, args ...interface{}) {
-	fmt.Printf("DEBUG: %s", fmt.Sprintf(s, args))
+	fmt.Printf("DEBUG: %s", fmt.Sprintf(s, args...))
 }
 
 // Info sends an info log
@@ -38,7 +38,7 @@ func Info(info string) {
 
 // Infof sends an info log with parameters
 func Infof(s string, v ...interface{}) {
-	msg := fmt.Sprintf(s, v)
+	msg := fmt.Sprintf(s, v...)
 	fmt.Printf("[EVENT] %s", msg)
 }
 
@@ -11,38 +11,74 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ci-brakeman/github"
 	"github.com/ci-brakeman/handlers"
 	"github.com/ci-brakeman/logger"
-	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/ci-brakeman/policy"
 
 	"github.com/joho/godotenv"
 )
 
-var githubToken, githubInstallationID string
 var gitHubAppID, gitHubKeyData string
+var scanWorkers, scanQueueDepth int
+var workspaceDir, historyDir string
+var historyMaxRecords int
+var deliveryTTL, maxPayloadAge time.Duration
+var scanActions []string
+var forkPolicy, forkLabel string
+var statusToken string
+var gitHubTimeout, gitHubRateLimitWait time.Duration
 
 func main() {
 
 	initEnviron()
 
-	// get initial auth token
-	setupAuth()
+	// client of the GitHub App, installation tokens are requested with it when needed
+	if err := setupGitHub(); err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
 
-	// Create a tmp folder if it does not exist
-	createTmpFolder()
+	// Create the workspace folder and remove leftovers of crashed scans
+	if err := handlers.SetupWorkspaces(workspaceDir); err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
 
-	// auth JWT is only valid for 10 minutes, so get a new one every 7
-	ticker := time.NewTicker(7 * time.Minute)
-	go func() {
-		for range ticker.C {
-			// get a new Auth token
-			setupAuth()
+	// open the history of all scans
+	if err := handlers.SetupHistory(historyDir, historyMaxRecords); err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
 
-		}
-	}()
+	// remember deliveries to skip duplicates and reject replays of old payloads
+	handlers.SetupDeliveries(deliveryTTL, maxPayloadAge)
+
+	// policy deciding the conclusion of the check runs
+	scanPolicy, err := policy.FromEnv()
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+	handlers.SetupPolicy(scanPolicy)
+
+	// pull_request actions that start a scan
+	if len(scanActions) > 0 {
+		handlers.SetupScanActions(scanActions)
+	}
+
+	// whether pull requests from forks are scanned
+	if err := handlers.SetupForks(forkPolicy, forkLabel); err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	// start the workers processing the scan queue
+	handlers.SetupQueue(scanWorkers, scanQueueDepth)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -51,6 +87,10 @@ func main() {
 
 	http.Handle("/", handlers.AuthCheck(http.HandlerFunc(handlers.Catcher)))
 	http.Handle("/hook", handlers.AuthCheck(http.HandlerFunc(handlers.Catcher)))
+	// the details of the status and the history name repositories, they are only shown with the token
+	handlers.SetupStatus(statusToken)
+	http.HandleFunc("/status", handlers.Status)
+	http.HandleFunc("/history", handlers.History)
 
 	http.ListenAndServe(":"+port, nil)
 
@@ -63,61 +103,122 @@ func initEnviron() (err error) {
 	environ := os.Getenv("ENVIRON")
 	logger.Setup(environ)
 
-	githubInstallationID = os.Getenv("GITHUB_INSTALLID")
 	gitHubAppID = os.Getenv("GITHUB_APPID")
 	gitHubKeyData = os.Getenv("GITHUB_PRIVATE_KEY")
+	// how long a call to the GitHub API may take
+	gitHubTimeout = envDuration("GITHUB_TIMEOUT", github.DefaultTimeout)
+	// how long a call may wait for a rate limit to reset
+	gitHubRateLimitWait = envDuration("GITHUB_RATE_LIMIT_WAIT", 15*time.Minute)
+
+	// number of scans that may run at the same time and how many may wait
+	scanWorkers = envInt("SCAN_WORKERS", 2)
+	scanQueueDepth = envInt("SCAN_QUEUE_DEPTH", 20)
+
+	// folder holding the workspaces of the scan jobs
+	workspaceDir = os.Getenv("WORKSPACE_DIR")
+	if workspaceDir == "" {
+		workspaceDir = "tmp"
+	}
 
-	return nil
-}
-
-func setupAuth() error {
+	// comma separated pull_request actions that start a scan
+	for _, a := range strings.Split(os.Getenv("SCAN_ACTIONS"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			scanActions = append(scanActions, a)
+		}
+	}
 
-	var err error
-	if github.JWTToken, err = generateJWT(); err != nil {
-		logger.Error(err)
-		return err
+	// scan, skip or wait for the approval of pull requests from forks
+	forkPolicy = os.Getenv("FORK_PRS")
+	if forkPolicy == "" {
+		forkPolicy = handlers.ForkScan
 	}
+	// label maintainers add to approve the scan of a pull request from a fork
+	forkLabel = os.Getenv("FORK_LABEL")
 
-	// try at least 3 times to get a token
-	for i := 0; i < 3; i++ {
-		if err = github.GetAccessToken(githubInstallationID); err != nil {
-			logger.Error(fmt.Errorf("Auth attempt: %d Err: %s", i, err))
-		} else {
-			break
-		}
+	// how long delivery GUIDs are remembered and how old a payload may be
+	deliveryTTL = envDuration("DELIVERY_TTL", 24*time.Hour)
+	maxPayloadAge = envDuration("WEBHOOK_MAX_AGE", time.Hour)
+
+	// bearer token that shows the jobs, workspaces and rate limits on /status
+	statusToken = os.Getenv("STATUS_TOKEN")
+
+	// folder holding the history of all scans
+	historyDir = os.Getenv("HISTORY_DIR")
+	if historyDir == "" {
+		historyDir = "history"
 	}
-	return err
-}
+	// how many scans are kept per repository, 0 keeps all of them
+	historyMaxRecords = envInt("HISTORY_MAX_RECORDS", 0)
 
-func generateJWT() (jwtToken string, err error) {
+	return nil
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
-		"iat": time.Now().Unix(),
-		"iss": gitHubAppID,
-		"exp": time.Now().Add(time.Minute * 10).Unix(),
-	})
+// envInt reads an integer from the environment, falling back to def when
+// the variable is not set or is not a valid number
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		logger.Error(fmt.Errorf("invalid value for %s: %s", name, err))
+		return def
+	}
+	return i
+}
 
-	key, _ := jwt.ParseRSAPrivateKeyFromPEM([]byte(gitHubKeyData))
-	jwtToken, err = token.SignedString(key)
+// envDuration reads a duration like 90m from the environment, falling back to
+// def when the variable is not set or is not a valid duration
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
 	if err != nil {
-		return
+		logger.Error(fmt.Errorf("invalid value for %s: %s", name, err))
+		return def
 	}
-	return
+	return d
 }
 
-// if tmp folder does not exist, create it. This is mostly used when the application is first started.
-func createTmpFolder() {
-	_, err := os.Stat("tmp")
+// setupGitHub creates the client of the GitHub App, which signs its own JWTs
+// with the private key of the App
+func setupGitHub() error {
+	auth, err := github.NewAppTokenSource(gitHubAppID, []byte(gitHubKeyData))
+	if err != nil {
+		return err
+	}
+	app := github.NewClient(auth)
+	app.Name = "app"
+
+	// waits for rate limits to reset and retries failed requests
+	transport := github.NewRateLimitTransport(nil)
+	transport.Timeout = gitHubTimeout
+	transport.MaxWait = gitHubRateLimitWait
+	app.HTTPClient = &http.Client{Transport: transport}
+
+	// GitHub Enterprise Server
+	if apiURL := os.Getenv("GITHUB_API_URL"); apiURL != "" {
+		if err := app.SetEnterpriseURLs(apiURL, os.Getenv("GITHUB_WEB_URL"), os.Getenv("GITHUB_CLONE_URL")); err != nil {
+			return err
+		}
+	}
 
-	if os.IsNotExist(err) {
-		errDir := os.MkdirAll("tmp", 0755)
-		if errDir != nil {
-			logger.Error(err)
-		} else {
-			fmt.Println("[createTmpFolder] tmp folder created")
+	// the App comments as <slug>[bot], its slug is looked up unless it is configured
+	appSlug := os.Getenv("GITHUB_APP_SLUG")
+	if appSlug == "" {
+		meta, err := app.GetApp()
+		if err != nil {
+			return fmt.Errorf("Couldn't fetch the GitHub App: %s", err)
+		}
+		if meta.Slug == nil || *meta.Slug == "" {
+			return fmt.Errorf("GitHub App %s has no slug, set GITHUB_APP_SLUG", gitHubAppID)
 		}
-	} else {
-		fmt.Println("[createTmpFolder] Skipping tmp folder creation. Folder already exists.")
+		appSlug = *meta.Slug
 	}
-	return
+
+	handlers.SetupGitHub(github.NewInstallations(app), appSlug)
+	return nil
 }
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package policy - policy
+// Contains the logic to decide the conclusion of a check run from the scan results
+package policy
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ci-brakeman/scanner"
+)
+
+// confidenceRank orders the brakeman confidence levels, lower is more confident
+var confidenceRank = map[string]int{
+	"High":   0,
+	"Medium": 1,
+	"Weak":   2,
+	"Low":    2,
+}
+
+// Policy describes which warnings are acceptable and which should block a pull request
+type Policy struct {
+	// MinConfidence blocks on warnings with at least this confidence: High, Medium or Weak.
+	// Empty means no warning is blocked because of its confidence.
+	MinConfidence string `yaml:"min_confidence" json:"min_confidence,omitempty"`
+	// BlockWarningTypes blocks on warnings of these types, e.g. "SQL Injection"
+	BlockWarningTypes []string `yaml:"block_warning_types" json:"block_warning_types,omitempty"`
+	// BlockCheckNames blocks on warnings of these brakeman checks, e.g. "CheckSQL"
+	BlockCheckNames []string `yaml:"block_check_names" json:"block_check_names,omitempty"`
+	// MaxWarnings blocks when there are more warnings than this, a negative number means no limit
+	MaxWarnings int `yaml:"max_warnings" json:"max_warnings"`
+	// OnlyNew only takes the warnings introduced by the pull request into account
+	OnlyNew bool `yaml:"only_new" json:"only_new"`
+	// FailConclusion is the conclusion when the policy is violated: failure or action_required
+	FailConclusion string `yaml:"fail_conclusion" json:"fail_conclusion"`
+	// WarnConclusion is the conclusion when there are warnings that don't violate the policy: neutral or success
+	WarnConclusion string `yaml:"warn_conclusion" json:"warn_conclusion"`
+}
+
+// Decision is the outcome of evaluating a policy
+type Decision struct {
+	Conclusion string
+	// Reasons explains why the policy was violated, empty when it wasn't
+	Reasons []string
+	// Violations holds the warnings that violated the policy
+	Violations []scanner.WarningInfo
+}
+
+// Default returns the policy used when nothing is configured. It never blocks
+// and marks pull requests that introduce warnings as neutral.
+func Default() Policy {
+	return Policy{
+		MaxWarnings:    -1,
+		OnlyNew:        true,
+		FailConclusion: "failure",
+		WarnConclusion: "neutral",
+	}
+}
+
+// FromEnv returns the default policy with the overrides set in the environment
+func FromEnv() (p Policy, err error) {
+	p = Default()
+
+	p.MinConfidence = os.Getenv("POLICY_MIN_CONFIDENCE")
+	p.BlockWarningTypes = splitList(os.Getenv("POLICY_BLOCK_WARNING_TYPES"))
+	p.BlockCheckNames = splitList(os.Getenv("POLICY_BLOCK_CHECK_NAMES"))
+	if v := os.Getenv("POLICY_MAX_WARNINGS"); v != "" {
+		if p.MaxWarnings, err = strconv.Atoi(v); err != nil {
+			return p, fmt.Errorf("invalid value for POLICY_MAX_WARNINGS: %s", err)
+		}
+	}
+	if v := os.Getenv("POLICY_ONLY_NEW"); v != "" {
+		if p.OnlyNew, err = strconv.ParseBool(v); err != nil {
+			return p, fmt.Errorf("invalid value for POLICY_ONLY_NEW: %s", err)
+		}
+	}
+	if v := os.Getenv("POLICY_FAIL_CONCLUSION"); v != "" {
+		p.FailConclusion = v
+	}
+	if v := os.Getenv("POLICY_WARN_CONCLUSION"); v != "" {
+		p.WarnConclusion = v
+	}
+	return p, p.Validate()
+}
+
+// Validate checks that the policy only holds known values
+func (p Policy) Validate() error {
+	if _, ok := confidenceRank[p.MinConfidence]; p.MinConfidence != "" && !ok {
+		return fmt.Errorf("invalid minimum confidence %q, expected High, Medium or Weak", p.MinConfidence)
+	}
+	if p.FailConclusion != "failure" && p.FailConclusion != "action_required" {
+		return fmt.Errorf("invalid fail conclusion %q, expected failure or action_required", p.FailConclusion)
+	}
+	if p.WarnConclusion != "neutral" && p.WarnConclusion != "success" {
+		return fmt.Errorf("invalid warn conclusion %q, expected neutral or success", p.WarnConclusion)
+	}
+	return nil
+}
+
+// Evaluate decides the conclusion of a check run from the warnings of a scan
+func (p Policy) Evaluate(diff scanner.Diff) (d Decision) {
+	warnings := diff.New
+	if !p.OnlyNew {
+		warnings = append(append([]scanner.WarningInfo{}, diff.New...), diff.PreExisting...)
+	}
+
+	for _, w := range warnings {
+		if reason := p.violation(w); reason != "" {
+			d.Reasons = append(d.Reasons, fmt.Sprintf("%s in %s:%d: %s", w.WarningType, w.File, w.Line, reason))
+			d.Violations = append(d.Violations, w)
+		}
+	}
+	if p.MaxWarnings >= 0 && len(warnings) > p.MaxWarnings {
+		d.Reasons = append(d.Reasons, fmt.Sprintf("%d warnings found, at most %d are allowed", len(warnings), p.MaxWarnings))
+	}
+
+	switch {
+	case len(d.Reasons) > 0:
+		d.Conclusion = p.FailConclusion
+	case len(warnings) > 0:
+		d.Conclusion = p.WarnConclusion
+	default:
+		d.Conclusion = "success"
+	}
+	return
+}
+
+// violation returns why a warning violates the policy, or an empty string if it doesn't
+func (p Policy) violation(w scanner.WarningInfo) string {
+	if p.MinConfidence != "" {
+		if rank, ok := confidenceRank[w.Confidence]; ok && rank <= confidenceRank[p.MinConfidence] {
+			return fmt.Sprintf("confidence %s", w.Confidence)
+		}
+	}
+	for _, t := range p.BlockWarningTypes {
+		if strings.EqualFold(t, w.WarningType) {
+			return fmt.Sprintf("warning type %s is blocked", w.WarningType)
+		}
+	}
+	for _, c := range p.BlockCheckNames {
+		if strings.EqualFold(c, w.CheckName) {
+			return fmt.Sprintf("check %s is blocked", w.CheckName)
+		}
+	}
+	return ""
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(s string) (list []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package policy
+
+import (
+	"testing"
+
+	"github.com/ci-brakeman/scanner"
+)
+
+func TestEvaluate(t *testing.T) {
+	sqli := scanner.WarningInfo{WarningType: "SQL Injection", CheckName: "CheckSQL", Confidence: "High", File: "app/models/user.rb", Line: 3}
+	xss := scanner.WarningInfo{WarningType: "Cross-Site Scripting", CheckName: "CheckCrossSiteScripting", Confidence: "Medium", File: "app/views/a.html.erb", Line: 1}
+	redirect := scanner.WarningInfo{WarningType: "Redirect", CheckName: "CheckRedirect", Confidence: "Weak", File: "app/controllers/a.rb", Line: 8}
+
+	withDefault := func(f func(p *Policy)) Policy {
+		p := Default()
+		f(&p)
+		return p
+	}
+
+	tests := []struct {
+		name       string
+		policy     Policy
+		diff       scanner.Diff
+		conclusion string
+		violations int
+		reasons    int
+	}{
+		{
+			name:       "no warnings",
+			policy:     Default(),
+			conclusion: "success",
+		},
+		{
+			name:       "default policy with new warnings",
+			policy:     Default(),
+			diff:       scanner.Diff{New: []scanner.WarningInfo{sqli}},
+			conclusion: "neutral",
+		},
+		{
+			name:       "only pre-existing warnings",
+			policy:     withDefault(func(p *Policy) { p.MinConfidence = "High" }),
+			diff:       scanner.Diff{PreExisting: []scanner.WarningInfo{sqli}},
+			conclusion: "success",
+		},
+		{
+			name: "pre-existing warnings count when not only new",
+			policy: withDefault(func(p *Policy) {
+				p.MinConfidence = "High"
+				p.OnlyNew = false
+			}),
+			diff:       scanner.Diff{PreExisting: []scanner.WarningInfo{sqli}},
+			conclusion: "failure",
+			violations: 1,
+			reasons:    1,
+		},
+		{
+			name:       "minimum confidence",
+			policy:     withDefault(func(p *Policy) { p.MinConfidence = "Medium" }),
+			diff:       scanner.Diff{New: []scanner.WarningInfo{sqli, xss, redirect}},
+			conclusion: "failure",
+			violations: 2,
+			reasons:    2,
+		},
+		{
+			name:       "blocked warning type",
+			policy:     withDefault(func(p *Policy) { p.BlockWarningTypes = []string{"sql injection"} }),
+			diff:       scanner.Diff{New: []scanner.WarningInfo{sqli, xss}},
+			conclusion: "failure",
+			violations: 1,
+			reasons:    1,
+		},
+		{
+			name:       "blocked check name",
+			policy:     withDefault(func(p *Policy) { p.BlockCheckNames = []string{"CheckRedirect"} }),
+			diff:       scanner.Diff{New: []scanner.WarningInfo{sqli, redirect}},
+			conclusion: "failure",
+			violations: 1,
+			reasons:    1,
+		},
+		{
+			name:       "too many warnings",
+			policy:     withDefault(func(p *Policy) { p.MaxWarnings = 1 }),
+			diff:       scanner.Diff{New: []scanner.WarningInfo{sqli, xss}},
+			conclusion: "failure",
+			reasons:    1,
+		},
+		{
+			name:       "at most the allowed number of warnings",
+			policy:     withDefault(func(p *Policy) { p.MaxWarnings = 2 }),
+			diff:       scanner.Diff{New: []scanner.WarningInfo{sqli, xss}},
+			conclusion: "neutral",
+		},
+		{
+			name: "custom conclusions",
+			policy: withDefault(func(p *Policy) {
+				p.BlockWarningTypes = []string{"Redirect"}
+				p.FailConclusion = "action_required"
+			}),
+			diff:       scanner.Diff{New: []scanner.WarningInfo{redirect}},
+			conclusion: "action_required",
+			violations: 1,
+			reasons:    1,
+		},
+		{
+			name:       "warnings marked as success",
+			policy:     withDefault(func(p *Policy) { p.WarnConclusion = "success" }),
+			diff:       scanner.Diff{New: []scanner.WarningInfo{xss}},
+			conclusion: "success",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := tt.policy.Evaluate(tt.diff)
+			if d.Conclusion != tt.conclusion {
+				t.Errorf("Conclusion = %q, want %q", d.Conclusion, tt.conclusion)
+			}
+			if len(d.Violations) != tt.violations {
+				t.Errorf("got %d violations, want %d", len(d.Violations), tt.violations)
+			}
+			if len(d.Reasons) != tt.reasons {
+				t.Errorf("got reasons %q, want %d", d.Reasons, tt.reasons)
+			}
+		})
+	}
+}
+
+func TestEvaluateDoesNotModifyDiff(t *testing.T) {
+	newWarnings := make([]scanner.WarningInfo, 1, 2)
+	newWarnings[0] = scanner.WarningInfo{FingerPrint: "new"}
+	diff := scanner.Diff{New: newWarnings, PreExisting: []scanner.WarningInfo{{FingerPrint: "old"}}}
+
+	p := Default()
+	p.OnlyNew = false
+	p.Evaluate(diff)
+
+	if got := newWarnings[:2][1].FingerPrint; got != "" {
+		t.Errorf("Evaluate() wrote %q behind the new warnings", got)
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package queue - queue
+// Contains a bounded job queue that is serviced by a fixed pool of workers
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ci-brakeman/logger"
+)
+
+// ErrQueueFull is returned by Enqueue when there is no room left in the queue
+var ErrQueueFull = errors.New("scan queue is full")
+
+// Job is a single unit of work, usually a scan of one pull request
+type Job struct {
+	// ID identifies the job, this is normally the webhook delivery GUID
+	ID string
+	// Key identifies what the job is working on, e.g. owner/repo#1
+	Key string
+	// Run does the actual work
+	Run func(ctx context.Context)
+
+	enqueuedAt time.Time
+	startedAt  time.Time
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// JobInfo is a read-only view of a job, used to report the queue state
+type JobInfo struct {
+	ID         string    `json:"id"`
+	Key        string    `json:"key"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+}
+
+// Stats holds a snapshot of the queue state
+type Stats struct {
+	Workers   int       `json:"workers"`
+	Depth     int       `json:"depth"`
+	Queued    int       `json:"queued"`
+	Running   int       `json:"running"`
+	Processed uint64    `json:"processed"`
+	Failed    uint64    `json:"failed"`
+	Rejected  uint64    `json:"rejected"`
+	Cancelled uint64    `json:"cancelled"`
+	Jobs      []JobInfo `json:"jobs"`
+}
+
+// Queue is a bounded FIFO of jobs with a fixed number of workers
+type Queue struct {
+	workers int
+	depth   int
+	jobs    chan *Job
+
+	mu        sync.Mutex
+	queued    map[*Job]struct{}
+	running   map[*Job]struct{}
+	processed uint64
+	failed    uint64
+	rejected  uint64
+	cancelled uint64
+}
+
+// New creates a queue holding at most depth waiting jobs, which are
+// processed by the given number of workers. Call Start to begin processing.
+func New(workers, depth int) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	if depth < 0 {
+		depth = 0
+	}
+	return &Queue{
+		workers: workers,
+		depth:   depth,
+		jobs:    make(chan *Job, depth),
+		queued:  make(map[*Job]struct{}),
+		running: make(map[*Job]struct{}),
+	}
+}
+
+// Start launches the workers
+func (q *Queue) Start() {
+	for i := 0; i < q.workers; i++ {
+		go q.worker()
+	}
+}
+
+// Enqueue adds a job to the queue without blocking. ErrQueueFull is returned
+// when the queue is at capacity, the caller should then ask for a retry later.
+func (q *Queue) Enqueue(job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job.enqueuedAt = time.Now()
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	select {
+	case q.jobs <- job:
+		q.queued[job] = struct{}{}
+		return nil
+	default:
+		job.cancel()
+		q.rejected++
+		return ErrQueueFull
+	}
+}
+
+// Cancel cancels all queued and running jobs with the given key and returns
+// how many jobs were cancelled. Queued jobs are dropped, running jobs see
+// their context cancelled and are expected to stop as soon as possible.
+func (q *Queue) Cancel(key string) (n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, jobs := range []map[*Job]struct{}{q.queued, q.running} {
+		for j := range jobs {
+			if j.Key == key && j.ctx.Err() == nil {
+				j.cancel()
+				n++
+			}
+		}
+	}
+	q.cancelled += uint64(n)
+	return
+}
+
+// Stats returns a snapshot of the queue state
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := Stats{
+		Workers:   q.workers,
+		Depth:     q.depth,
+		Queued:    len(q.queued),
+		Running:   len(q.running),
+		Processed: q.processed,
+		Failed:    q.failed,
+		Rejected:  q.rejected,
+		Cancelled: q.cancelled,
+		Jobs:      []JobInfo{},
+	}
+	for j := range q.running {
+		s.Jobs = append(s.Jobs, JobInfo{ID: j.ID, Key: j.Key, EnqueuedAt: j.enqueuedAt, StartedAt: j.startedAt})
+	}
+	for j := range q.queued {
+		s.Jobs = append(s.Jobs, JobInfo{ID: j.ID, Key: j.Key, EnqueuedAt: j.enqueuedAt})
+	}
+	return s
+}
+
+func (q *Queue) worker() {
+	for job := range q.jobs {
+		q.mu.Lock()
+		delete(q.queued, job)
+		if job.ctx.Err() != nil {
+			// cancelled while waiting in the queue
+			q.mu.Unlock()
+			continue
+		}
+		job.startedAt = time.Now()
+		q.running[job] = struct{}{}
+		q.mu.Unlock()
+
+		ok := q.run(job)
+
+		q.mu.Lock()
+		delete(q.running, job)
+		job.cancel()
+		q.processed++
+		if !ok {
+			q.failed++
+		}
+		q.mu.Unlock()
+	}
+}
+
+// run executes a single job. A panicking job must not take the worker
+// (and with it the whole service) down, so panics are recovered and logged.
+func (q *Queue) run(job *Job) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(fmt.Errorf("job %s (%s) panicked: %v", job.ID, job.Key, r))
+			ok = false
+		}
+	}()
+
+	logger.CreateBreadcrumb("queue", fmt.Sprintf("starting job=%s,key=%s", job.ID, job.Key))
+	job.Run(job.ctx)
+	return true
+}
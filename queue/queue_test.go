@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitFor fails the test when cond doesn't hold within a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// blockingJob returns a job that signals when it starts and runs until
+// release is closed or its context is cancelled
+func blockingJob(id, key string, started chan<- string, release <-chan struct{}) *Job {
+	return &Job{ID: id, Key: key, Run: func(ctx context.Context) {
+		started <- id
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+	}}
+}
+
+func TestEnqueueRejectsWhenFull(t *testing.T) {
+	q := New(1, 2)
+	started := make(chan string, 1)
+	release := make(chan struct{})
+	defer close(release)
+	q.Start()
+
+	// the worker takes the first job, the next two fill the queue
+	if err := q.Enqueue(blockingJob("1", "a#1", started, release)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	for _, id := range []string{"2", "3"} {
+		if err := q.Enqueue(blockingJob(id, "a#"+id, started, release)); err != nil {
+			t.Fatalf("Enqueue(%s) = %v", id, err)
+		}
+	}
+
+	if err := q.Enqueue(blockingJob("4", "a#4", started, release)); err != ErrQueueFull {
+		t.Errorf("Enqueue() on a full queue = %v, want ErrQueueFull", err)
+	}
+	s := q.Stats()
+	if s.Running != 1 || s.Queued != 2 || s.Rejected != 1 {
+		t.Errorf("Stats() = %+v, want 1 running, 2 queued, 1 rejected", s)
+	}
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	q := New(1, 2)
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	q.Start()
+
+	if err := q.Enqueue(blockingJob("1", "a#1", started, release)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	ran := false
+	queued := &Job{ID: "2", Key: "a#2", Run: func(ctx context.Context) { ran = true }}
+	if err := q.Enqueue(queued); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := q.Cancel("a#2"); n != 1 {
+		t.Errorf("Cancel() = %d, want 1", n)
+	}
+	// cancelling twice doesn't count the job again
+	if n := q.Cancel("a#2"); n != 0 {
+		t.Errorf("second Cancel() = %d, want 0", n)
+	}
+
+	close(release)
+	waitFor(t, "the queue to drain", func() bool {
+		s := q.Stats()
+		return s.Queued == 0 && s.Running == 0
+	})
+	if ran {
+		t.Error("a job cancelled while queued was run")
+	}
+	if s := q.Stats(); s.Processed != 1 || s.Cancelled != 1 {
+		t.Errorf("Stats() = %+v, want 1 processed, 1 cancelled", s)
+	}
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	q := New(2, 1)
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	defer close(release)
+	q.Start()
+
+	stopped := make(chan error, 1)
+	running := &Job{ID: "1", Key: "a#1", Run: func(ctx context.Context) {
+		started <- "1"
+		<-ctx.Done()
+		stopped <- ctx.Err()
+	}}
+	if err := q.Enqueue(running); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := q.Enqueue(blockingJob("2", "a#2", started, release)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	if n := q.Cancel("a#1"); n != 1 {
+		t.Errorf("Cancel() = %d, want 1", n)
+	}
+	select {
+	case err := <-stopped:
+		if err != context.Canceled {
+			t.Errorf("context error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the running job was not cancelled")
+	}
+
+	// other keys keep running
+	waitFor(t, "the cancelled job to finish", func() bool { return q.Stats().Running == 1 })
+	if jobs := q.Stats().Jobs; len(jobs) != 1 || jobs[0].Key != "a#2" {
+		t.Errorf("Jobs = %+v, want only a#2", jobs)
+	}
+}
+
+func TestPanickingJobKeepsWorker(t *testing.T) {
+	q := New(1, 1)
+	q.Start()
+
+	if err := q.Enqueue(&Job{ID: "1", Key: "a#1", Run: func(ctx context.Context) { panic("boom") }}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	waitFor(t, "the panicking job to be processed", func() bool { return q.Stats().Processed == 1 })
+	if err := q.Enqueue(&Job{ID: "2", Key: "a#2", Run: func(ctx context.Context) { close(done) }}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the worker stopped after a job panicked")
+	}
+
+	waitFor(t, "the second job to be processed", func() bool { return q.Stats().Processed == 2 })
+	if s := q.Stats(); s.Failed != 1 {
+		t.Errorf("Stats() = %+v, want 1 failed", s)
+	}
+}
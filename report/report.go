@@ -0,0 +1,348 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package report - report
+// Contains the logic to render the result of a scan as Markdown
+package report
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ci-brakeman/scanner"
+)
+
+// MaxLength is the length a report is cut at. GitHub accepts up to 65535
+// characters in a comment or the text of a check run, this leaves room for
+// the mentions and the marker added to the comment.
+const MaxLength = 64000
+
+// truncated is appended to a report that had to be cut at MaxLength
+const truncated = "\n_The report was too long and has been truncated._\n"
+
+// confidences is the order the brakeman confidence levels are listed in
+var confidences = []string{"High", "Medium", "Weak"}
+
+// Commit is a commit that was scanned
+type Commit struct {
+	// Kind is head or merge
+	Kind string
+	SHA  string
+}
+
+// Report holds the result of a scan and what is needed to link to the code
+type Report struct {
+	// WebURL is the address of the repository in the browser, e.g. https://github.com/octo-org/octo-repo
+	WebURL string
+	// HeadSHA is the commit that was checked out and scanned, the code is linked in it
+	HeadSHA string
+	// Scanned are the commits of a pull request that were scanned, when empty just the HeadSHA was
+	Scanned []Commit
+	// BaseSHA is the commit the head was compared with, empty if there is none
+	BaseSHA string
+	Diff    scanner.Diff
+	// Apps are the Rails apps that were scanned, the warnings are counted per app when there are several
+	Apps []string
+	// Notes are shown at the top of the report, e.g. why the base couldn't be compared with
+	Notes []string
+	// Conclusion of the check run
+	Conclusion string
+	// Violations are the reasons the policy gave for the conclusion
+	Violations []string
+	// Error replaces the findings when brakeman couldn't scan the code
+	Error string
+}
+
+// Markdown renders the report. The new and fixed warnings are grouped by file
+// in collapsible sections, when the report gets too long the pre-existing
+// warnings are only counted. When it is still too long, whole files are left
+// out, so no section or code block is cut in the middle.
+func (r *Report) Markdown() string {
+	md := r.render(true, math.MaxInt)
+	if len(md) <= MaxLength {
+		return md
+	}
+	if md = r.render(false, math.MaxInt); len(md) <= MaxLength {
+		return md
+	}
+
+	// the most files that fit, the length grows with every file shown
+	files := len(fileNames(r.Diff.New)) + len(fileNames(r.Diff.Fixed))
+	fit := sort.Search(files+1, func(n int) bool { return len(r.render(false, n)) > MaxLength }) - 1
+	if fit >= 0 {
+		return r.render(false, fit)
+	}
+
+	// only the notes and violations are left, they are cut at a line
+	md = r.render(false, 0)
+	cut := strings.LastIndexByte(md[:MaxLength-len(truncated)], '\n')
+	return md[:cut+1] + truncated
+}
+
+// render renders the report with the details of at most maxFiles files
+func (r *Report) render(preExistingDetails bool, maxFiles int) string {
+	var b strings.Builder
+	b.WriteString("## Brakeman Scan Report\n\n")
+
+	for _, note := range r.Notes {
+		fmt.Fprintf(&b, "> %s\n\n", html.EscapeString(note))
+	}
+
+	if r.Error != "" {
+		fmt.Fprintf(&b, "**Error:** %s\n", html.EscapeString(r.Error))
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "**Conclusion:** `%s`  \n", r.Conclusion)
+	r.scannedCommits(&b)
+	if r.BaseSHA != "" {
+		fmt.Fprintf(&b, ", compared with %s", r.commitLink(r.BaseSHA))
+	}
+	b.WriteString("\n\n")
+
+	if len(r.Violations) > 0 {
+		b.WriteString("### Policy violations\n\n")
+		for _, v := range r.Violations {
+			fmt.Fprintf(&b, "- %s\n", html.EscapeString(v))
+		}
+		b.WriteString("\n")
+	}
+
+	d := r.Diff
+	if len(d.New)+len(d.Fixed)+len(d.PreExisting) == 0 {
+		b.WriteString("No warnings found.\n")
+		return b.String()
+	}
+
+	b.WriteString("### Summary\n\n")
+	if len(r.Apps) > 1 {
+		r.appTable(&b)
+	}
+	r.summaryTable(&b, "Confidence", func(w scanner.WarningInfo) string { return w.Confidence }, orderConfidences)
+	r.summaryTable(&b, "Warning type", func(w scanner.WarningInfo) string { return w.WarningType }, sort.Strings)
+
+	r.section(&b, "New warnings", r.HeadSHA, d.New, true, &maxFiles)
+	r.section(&b, "Fixed warnings", r.BaseSHA, d.Fixed, true, &maxFiles)
+	r.section(&b, "Pre-existing warnings", r.HeadSHA, d.PreExisting, preExistingDetails, &maxFiles)
+	return b.String()
+}
+
+// summaryTable counts the new, fixed and pre-existing warnings per value of the given column
+func (r *Report) summaryTable(b *strings.Builder, column string, value func(scanner.WarningInfo) string, order func([]string)) {
+	counts := map[string]*[3]int{}
+	var rows []string
+	for i, warnings := range [][]scanner.WarningInfo{r.Diff.New, r.Diff.Fixed, r.Diff.PreExisting} {
+		for _, w := range warnings {
+			v := value(w)
+			if counts[v] == nil {
+				counts[v] = &[3]int{}
+				rows = append(rows, v)
+			}
+			counts[v][i]++
+		}
+	}
+	order(rows)
+
+	fmt.Fprintf(b, "| %s | New | Fixed | Pre-existing |\n|---|---:|---:|---:|\n", column)
+	for _, v := range rows {
+		c := counts[v]
+		fmt.Fprintf(b, "| %s | %d | %d | %d |\n", tableCell(v), c[0], c[1], c[2])
+	}
+	b.WriteString("\n")
+}
+
+// appTable counts the new, fixed and pre-existing warnings of every app, also
+// of the apps without warnings
+func (r *Report) appTable(b *strings.Builder) {
+	counts := map[string]*[3]int{}
+	for _, app := range r.Apps {
+		counts[app] = &[3]int{}
+	}
+	for i, warnings := range [][]scanner.WarningInfo{r.Diff.New, r.Diff.Fixed, r.Diff.PreExisting} {
+		for _, w := range warnings {
+			// an app removed by the pull request only has fixed warnings
+			if counts[w.App] == nil {
+				counts[w.App] = &[3]int{}
+			}
+			counts[w.App][i]++
+		}
+	}
+	apps := make([]string, 0, len(counts))
+	for app := range counts {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+
+	b.WriteString("| App | New | Fixed | Pre-existing |\n|---|---:|---:|---:|\n")
+	for _, app := range apps {
+		c := counts[app]
+		fmt.Fprintf(b, "| %s | %d | %d | %d |\n", tableCell("/"+app), c[0], c[1], c[2])
+	}
+	b.WriteString("\n")
+}
+
+// section lists warnings grouped by file, each file in a collapsible section.
+// maxFiles is how many more files may be shown, the files left out are counted.
+func (r *Report) section(b *strings.Builder, title, sha string, warnings []scanner.WarningInfo, details bool, maxFiles *int) {
+	fmt.Fprintf(b, "### %s (%d)\n\n", title, len(warnings))
+	if len(warnings) == 0 || !details {
+		return
+	}
+
+	files := map[string][]scanner.WarningInfo{}
+	for _, w := range warnings {
+		files[w.File] = append(files[w.File], w)
+	}
+	names := fileNames(warnings)
+
+	for i, name := range names {
+		if *maxFiles <= 0 {
+			fmt.Fprintf(b, "_%d more files are not shown, the report was too long._\n\n", len(names)-i)
+			return
+		}
+		*maxFiles--
+
+		ws := files[name]
+		sort.SliceStable(ws, func(i, j int) bool { return ws[i].Line < ws[j].Line })
+
+		fmt.Fprintf(b, "<details>\n<summary><code>%s</code> (%d)</summary>\n\n", html.EscapeString(name), len(ws))
+		for _, w := range ws {
+			r.warning(b, sha, w)
+		}
+		b.WriteString("</details>\n\n")
+	}
+}
+
+// fileNames returns the files of the warnings, sorted
+func fileNames(warnings []scanner.WarningInfo) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, w := range warnings {
+		if !seen[w.File] {
+			seen[w.File] = true
+			names = append(names, w.File)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// warning renders a single warning with a link to its line of code
+func (r *Report) warning(b *strings.Builder, sha string, w scanner.WarningInfo) {
+	warningType := html.EscapeString(w.WarningType)
+	if w.Link != "" {
+		warningType = fmt.Sprintf("[%s](%s)", warningType, w.Link)
+	}
+	fmt.Fprintf(b, "#### %s (%s confidence)\n\n", warningType, html.EscapeString(w.Confidence))
+	fmt.Fprintf(b, "%s\n\n", html.EscapeString(w.Message))
+
+	location := fmt.Sprintf("%s:%d", w.File, w.Line)
+	if r.WebURL != "" && sha != "" {
+		location = fmt.Sprintf("[%s](%s/blob/%s/%s#L%d)", html.EscapeString(location), r.WebURL, sha, w.File, w.Line)
+	}
+	fmt.Fprintf(b, "Location: %s  \n", location)
+	if w.UserInput != "" {
+		fmt.Fprintf(b, "User input: %s  \n", codeSpan(w.UserInput))
+	}
+	fmt.Fprintf(b, "Fingerprint: `%s`\n\n", w.FingerPrint)
+
+	if w.Code != "" {
+		fence := codeFence(w.Code)
+		fmt.Fprintf(b, "%sruby\n%s\n%s\n\n", fence, w.Code, fence)
+	}
+}
+
+// scannedCommits names the commits that were scanned
+func (r *Report) scannedCommits(b *strings.Builder) {
+	if len(r.Scanned) == 0 {
+		fmt.Fprintf(b, "**Scanned commit:** %s", r.commitLink(r.HeadSHA))
+		return
+	}
+
+	var commits []string
+	for _, c := range r.Scanned {
+		name := "head"
+		if c.Kind == "merge" {
+			name = "merge commit"
+		}
+		commits = append(commits, fmt.Sprintf("%s %s", name, r.commitLink(c.SHA)))
+	}
+	label := "Scanned commit"
+	if len(commits) > 1 {
+		label += "s"
+	}
+	fmt.Fprintf(b, "**%s:** %s", label, strings.Join(commits, " and "))
+}
+
+// commitLink links to a commit, or just names it when the web URL is unknown
+func (r *Report) commitLink(sha string) string {
+	short := sha
+	if len(short) > 7 {
+		short = short[:7]
+	}
+	if r.WebURL == "" {
+		return "`" + short + "`"
+	}
+	return fmt.Sprintf("[`%s`](%s/commit/%s)", short, r.WebURL, sha)
+}
+
+// orderConfidences sorts the brakeman confidence levels from high to low
+func orderConfidences(values []string) {
+	rank := func(v string) int {
+		for i, c := range confidences {
+			if c == v {
+				return i
+			}
+		}
+		return len(confidences)
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		if rank(values[i]) != rank(values[j]) {
+			return rank(values[i]) < rank(values[j])
+		}
+		return values[i] < values[j]
+	})
+}
+
+// tableCell escapes a value for a Markdown table
+func tableCell(s string) string {
+	return strings.Replace(html.EscapeString(s), "|", "\\|", -1)
+}
+
+// codeSpan puts s in an inline code span that can't be closed by backticks in s
+func codeSpan(s string) string {
+	ticks := strings.Repeat("`", longestRun(s, '`')+1)
+	return ticks + " " + s + " " + ticks
+}
+
+// codeFence returns a code fence that can't be closed by backticks in the code
+func codeFence(code string) string {
+	n := longestRun(code, '`') + 1
+	if n < 3 {
+		n = 3
+	}
+	return strings.Repeat("`", n)
+}
+
+// longestRun returns the length of the longest run of c in s
+func longestRun(s string, c rune) (longest int) {
+	run := 0
+	for _, r := range s {
+		if r != c {
+			run = 0
+			continue
+		}
+		run++
+		if run > longest {
+			longest = run
+		}
+	}
+	return
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package report
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ci-brakeman/scanner"
+)
+
+// warnings returns a warning in each of n files, with code holding a code fence
+func warnings(prefix string, n int) []scanner.WarningInfo {
+	var ws []scanner.WarningInfo
+	for i := 0; i < n; i++ {
+		ws = append(ws, scanner.WarningInfo{
+			WarningType: "SQL Injection",
+			Confidence:  "High",
+			Message:     "Possible SQL injection",
+			File:        fmt.Sprintf("app/models/%s_%03d.rb", prefix, i),
+			Line:        i + 1,
+			FingerPrint: fmt.Sprintf("%s%064d", prefix, i),
+			Code:        "User.where(\"name = '#{params[:name]}'\")\n```\n" + strings.Repeat("x = 1\n", 40),
+		})
+	}
+	return ws
+}
+
+// checkMarkdown fails the test when the report is too long or leaves a
+// collapsible section or code block open
+func checkMarkdown(t *testing.T, md string) {
+	t.Helper()
+	if len(md) > MaxLength {
+		t.Errorf("report is %d long, want at most %d", len(md), MaxLength)
+	}
+	if open, closed := strings.Count(md, "<details>"), strings.Count(md, "</details>"); open != closed {
+		t.Errorf("report opens %d <details> and closes %d", open, closed)
+	}
+
+	// a code block is closed by a fence at least as long as the one that opened it
+	fence := ""
+	for _, line := range strings.Split(md, "\n") {
+		ticks := strings.TrimLeft(line, "`")
+		n := len(line) - len(ticks)
+		switch {
+		case fence == "" && n >= 3:
+			fence = line[:n]
+		case fence != "" && ticks == "" && n >= len(fence):
+			fence = ""
+		}
+	}
+	if fence != "" {
+		t.Error("report leaves a code block open")
+	}
+}
+
+// notShown matches the note on the files left out of a section
+var notShown = regexp.MustCompile(`_(\d+) more files are not shown, the report was too long._`)
+
+// shownFiles returns how many files of a section are shown and how many the
+// section says are not shown
+func shownFiles(t *testing.T, md, title string) (shown, hidden int) {
+	t.Helper()
+	start := strings.Index(md, "### "+title)
+	if start < 0 {
+		t.Fatalf("report has no %s section", title)
+	}
+	section := md[start+1:]
+	if end := strings.Index(section, "\n### "); end >= 0 {
+		section = section[:end]
+	}
+	shown = strings.Count(section, "<details>")
+	if m := notShown.FindStringSubmatch(section); m != nil {
+		hidden, _ = strconv.Atoi(m[1])
+	}
+	return
+}
+
+func TestMarkdownFits(t *testing.T) {
+	r := &Report{
+		HeadSHA: "1234567890abcdef",
+		BaseSHA: "fedcba0987654321",
+		Diff: scanner.Diff{
+			New:         warnings("new", 2),
+			PreExisting: warnings("old", 2),
+		},
+		Conclusion: "failure",
+	}
+	md := r.Markdown()
+	checkMarkdown(t, md)
+	if strings.Contains(md, "not shown") || strings.Contains(md, "truncated") {
+		t.Error("a short report was cut")
+	}
+	if shown, _ := shownFiles(t, md, "Pre-existing warnings"); shown != 2 {
+		t.Errorf("%d pre-existing files shown, want 2", shown)
+	}
+}
+
+func TestMarkdownTooLong(t *testing.T) {
+	tests := []struct {
+		name       string
+		new, fixed int
+	}{
+		{"new warnings cut", 400, 20},
+		{"fixed warnings cut", 100, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Report{
+				WebURL:  "https://github.com/acme/shop",
+				HeadSHA: "1234567890abcdef",
+				BaseSHA: "fedcba0987654321",
+				Diff: scanner.Diff{
+					New:         warnings("new", tt.new),
+					Fixed:       warnings("fixed", tt.fixed),
+					PreExisting: warnings("old", 50),
+				},
+				Conclusion: "failure",
+			}
+			md := r.Markdown()
+			checkMarkdown(t, md)
+
+			// the pre-existing warnings are only counted
+			if shown, _ := shownFiles(t, md, "Pre-existing warnings"); shown != 0 {
+				t.Errorf("%d pre-existing files shown, want none", shown)
+			}
+			newShown, newHidden := shownFiles(t, md, "New warnings")
+			fixedShown, fixedHidden := shownFiles(t, md, "Fixed warnings")
+			if newShown+newHidden != tt.new || fixedShown+fixedHidden != tt.fixed {
+				t.Errorf("new %d shown + %d hidden, fixed %d shown + %d hidden, want %d and %d files",
+					newShown, newHidden, fixedShown, fixedHidden, tt.new, tt.fixed)
+			}
+			if newShown+fixedShown == 0 {
+				t.Error("no files shown")
+			}
+			// new warnings are shown before fixed ones
+			if newHidden > 0 && fixedShown > 0 {
+				t.Errorf("%d fixed files shown while %d new ones are not", fixedShown, newHidden)
+			}
+		})
+	}
+}
+
+func TestMarkdownOnlyNotes(t *testing.T) {
+	r := &Report{
+		HeadSHA:    "1234567890abcdef",
+		Notes:      []string{strings.Repeat("The base branch couldn't be scanned. ", 2000)},
+		Conclusion: "neutral",
+		Diff:       scanner.Diff{New: warnings("new", 1)},
+	}
+	md := r.Markdown()
+	checkMarkdown(t, md)
+	if !strings.HasSuffix(md, truncated) {
+		t.Errorf("report doesn't end with the truncation note: %q", md[len(md)-100:])
+	}
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package sarif - sarif
+// Contains the logic to convert brakeman findings into a SARIF 2.1.0 log
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+package sarif
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ci-brakeman/scanner"
+)
+
+const (
+	schemaURI = "https://json.schemastore.org/sarif-2.1.0.json"
+	version   = "2.1.0"
+
+	// fingerprintKey is the partial fingerprint holding the brakeman fingerprint
+	fingerprintKey = "brakemanFingerprint/v1"
+)
+
+// Log is the top level object of a SARIF file
+type Log struct {
+	Schema  string `json:"$schema"`
+	Version string `json:"version"`
+	Runs    []Run  `json:"runs"`
+}
+
+// Run holds the results of a single run of a tool
+type Run struct {
+	Tool    Tool     `json:"tool"`
+	Results []Result `json:"results"`
+}
+
+// Tool describes the tool that produced the results
+type Tool struct {
+	Driver Driver `json:"driver"`
+}
+
+// Driver describes the tool component and the rules it checks
+type Driver struct {
+	Name           string `json:"name"`
+	Version        string `json:"version,omitempty"`
+	InformationURI string `json:"informationUri"`
+	Rules          []Rule `json:"rules"`
+}
+
+// Rule describes a single brakeman check
+type Rule struct {
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	ShortDescription Message    `json:"shortDescription"`
+	HelpURI          string     `json:"helpUri,omitempty"`
+	Properties       Properties `json:"properties,omitempty"`
+}
+
+// Result is a single warning
+type Result struct {
+	RuleID              string            `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Level               string            `json:"level"`
+	Message             Message           `json:"message"`
+	Locations           []Location        `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+	Properties          Properties        `json:"properties,omitempty"`
+}
+
+// Message is a plain text message
+type Message struct {
+	Text string `json:"text"`
+}
+
+// Location points to the code a result was found in
+type Location struct {
+	PhysicalLocation PhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []LogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+// PhysicalLocation is a region in a file
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Region           *Region          `json:"region,omitempty"`
+}
+
+// ArtifactLocation is a file, relative to the root of the repository
+type ArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId"`
+}
+
+// Region is a line in a file
+type Region struct {
+	StartLine int `json:"startLine"`
+}
+
+// LogicalLocation is the class or method a result was found in
+type LogicalLocation struct {
+	Name               string `json:"name,omitempty"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind,omitempty"`
+}
+
+// Properties holds additional properties of a rule or result
+type Properties map[string]interface{}
+
+// RuleID returns the ID of the rule a warning belongs to
+func RuleID(w scanner.WarningInfo) string {
+	return fmt.Sprintf("BRAKE%04d", w.WarningCode)
+}
+
+// level maps the brakeman confidence of a warning to a SARIF level
+func level(confidence string) string {
+	switch confidence {
+	case "High":
+		return "error"
+	case "Medium":
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+// FromFindings converts the findings of a brakeman scan into a SARIF log
+func FromFindings(finding scanner.Findings) *Log {
+	driver := Driver{
+		Name:           "Brakeman",
+		Version:        finding.ScanInfo.BrakemanVersion,
+		InformationURI: "https://brakemanscanner.org",
+		Rules:          []Rule{},
+	}
+	results := []Result{}
+	ruleIndex := make(map[string]int)
+
+	for _, w := range finding.Warnings {
+		id := RuleID(w)
+		idx, ok := ruleIndex[id]
+		if !ok {
+			idx = len(driver.Rules)
+			ruleIndex[id] = idx
+			driver.Rules = append(driver.Rules, Rule{
+				ID:               id,
+				Name:             w.CheckName,
+				ShortDescription: Message{Text: w.WarningType},
+				HelpURI:          w.Link,
+				Properties: Properties{
+					"tags": []string{"security", w.WarningType},
+				},
+			})
+		}
+
+		results = append(results, Result{
+			RuleID:              id,
+			RuleIndex:           idx,
+			Level:               level(w.Confidence),
+			Message:             Message{Text: message(w)},
+			Locations:           []Location{location(w)},
+			PartialFingerprints: map[string]string{fingerprintKey: w.FingerPrint},
+			Properties:          properties(w),
+		})
+	}
+
+	return &Log{
+		Schema:  schemaURI,
+		Version: version,
+		Runs: []Run{{
+			Tool:    Tool{Driver: driver},
+			Results: results,
+		}},
+	}
+}
+
+// message returns the text of a result, naming the tainted user input if there is any
+func message(w scanner.WarningInfo) string {
+	if w.UserInput != "" {
+		return fmt.Sprintf("%s (user input: %s)", w.Message, w.UserInput)
+	}
+	return w.Message
+}
+
+// location returns where a warning was found, both as file and line and
+// as the class and method brakeman reported
+func location(w scanner.WarningInfo) Location {
+	loc := Location{
+		PhysicalLocation: PhysicalLocation{
+			ArtifactLocation: ArtifactLocation{URI: w.File, URIBaseID: "%SRCROOT%"},
+		},
+	}
+	if w.Line > 0 {
+		loc.PhysicalLocation.Region = &Region{StartLine: w.Line}
+	}
+
+	switch {
+	case w.Location.Class != "" && w.Location.Method != "":
+		loc.LogicalLocations = []LogicalLocation{{
+			Name:               w.Location.Method,
+			FullyQualifiedName: fmt.Sprintf("%s#%s", w.Location.Class, w.Location.Method),
+			Kind:               "function",
+		}}
+	case w.Location.Class != "":
+		loc.LogicalLocations = []LogicalLocation{{
+			Name:               w.Location.Class,
+			FullyQualifiedName: w.Location.Class,
+			Kind:               "type",
+		}}
+	}
+	return loc
+}
+
+// properties keeps the brakeman specific details of a warning
+func properties(w scanner.WarningInfo) Properties {
+	p := Properties{
+		"confidence": w.Confidence,
+		"checkName":  w.CheckName,
+	}
+	if w.Code != "" {
+		p["code"] = w.Code
+	}
+	if w.UserInput != "" {
+		p["userInput"] = w.UserInput
+	}
+	if w.Location.Type != "" {
+		p["locationType"] = strings.ToLower(w.Location.Type)
+	}
+	return p
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package scanner
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxAppDepth is how deep below the root of a repository Rails apps are looked for
+const maxAppDepth = 4
+
+// skipDirs are never looked into for Rails apps
+var skipDirs = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+	"tmp":          true,
+	"log":          true,
+	"public":       true,
+}
+
+// railsGem matches a Gemfile line depending on rails
+var railsGem = regexp.MustCompile(`(?m)^\s*gem\s+['"](rails|railties)['"]`)
+
+// DiscoverApps returns the Rails apps in a folder, relative to the folder with
+// forward slashes. The root of the folder is returned as "". A directory is a
+// Rails app when it has an app directory along with config/application.rb or
+// a Gemfile depending on rails, which covers engines as well.
+func DiscoverApps(folder string) (apps []string, err error) {
+	err = filepath.WalkDir(folder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(folder, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		} else if strings.HasPrefix(d.Name(), ".") || skipDirs[d.Name()] || strings.Count(rel, "/") >= maxAppDepth {
+			return filepath.SkipDir
+		}
+
+		if isRailsApp(p) {
+			apps = append(apps, rel)
+		}
+		// the code of an app isn't searched for further apps
+		if d.Name() == "app" || (rel != "" && path.Base(rel) == "config") {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	sort.Strings(apps)
+	return
+}
+
+// isRailsApp reports whether a directory holds a Rails app or engine
+func isRailsApp(dir string) bool {
+	if info, err := os.Stat(filepath.Join(dir, "app")); err != nil || !info.IsDir() {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(dir, "config", "application.rb")); err == nil {
+		return true
+	}
+	gemfile, err := os.ReadFile(filepath.Join(dir, "Gemfile"))
+	return err == nil && railsGem.Match(gemfile)
+}
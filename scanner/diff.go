@@ -0,0 +1,41 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package scanner
+
+// Diff splits the warnings of two scans into the ones introduced by the
+// newer scan, the ones it fixed and the ones both scans found
+type Diff struct {
+	New         []WarningInfo `json:"new"`
+	Fixed       []WarningInfo `json:"fixed"`
+	PreExisting []WarningInfo `json:"pre_existing"`
+}
+
+// DiffWarnings compares the warnings of a base and a head scan by their
+// fingerprint. Brakeman fingerprints don't change when code moves around,
+// so a warning that only changed lines is not reported as new.
+func DiffWarnings(base, head []WarningInfo) (diff Diff) {
+	inBase := make(map[string]bool, len(base))
+	for _, w := range base {
+		inBase[w.FingerPrint] = true
+	}
+	inHead := make(map[string]bool, len(head))
+	for _, w := range head {
+		inHead[w.FingerPrint] = true
+		if inBase[w.FingerPrint] {
+			diff.PreExisting = append(diff.PreExisting, w)
+		} else {
+			diff.New = append(diff.New, w)
+		}
+	}
+	for _, w := range base {
+		if !inHead[w.FingerPrint] {
+			diff.Fixed = append(diff.Fixed, w)
+		}
+	}
+	return
+}
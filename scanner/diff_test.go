@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package scanner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffWarnings(t *testing.T) {
+	warning := func(fingerprint string, line int) WarningInfo {
+		return WarningInfo{FingerPrint: fingerprint, Line: line}
+	}
+
+	tests := []struct {
+		name string
+		base []WarningInfo
+		head []WarningInfo
+		want Diff
+	}{
+		{
+			name: "no warnings",
+		},
+		{
+			name: "no base scan",
+			head: []WarningInfo{warning("a", 1)},
+			want: Diff{New: []WarningInfo{warning("a", 1)}},
+		},
+		{
+			name: "all fixed",
+			base: []WarningInfo{warning("a", 1)},
+			want: Diff{Fixed: []WarningInfo{warning("a", 1)}},
+		},
+		{
+			name: "new, fixed and pre-existing",
+			base: []WarningInfo{warning("a", 1), warning("b", 2)},
+			head: []WarningInfo{warning("b", 2), warning("c", 3)},
+			want: Diff{
+				New:         []WarningInfo{warning("c", 3)},
+				Fixed:       []WarningInfo{warning("a", 1)},
+				PreExisting: []WarningInfo{warning("b", 2)},
+			},
+		},
+		{
+			name: "moved warning is pre-existing",
+			base: []WarningInfo{warning("a", 1)},
+			head: []WarningInfo{warning("a", 10)},
+			want: Diff{PreExisting: []WarningInfo{warning("a", 10)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffWarnings(tt.base, tt.head); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffWarnings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
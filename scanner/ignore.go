@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// IgnoreFile is the file, relative to the Rails app, brakeman reads the ignored warnings from
+// https://brakemanscanner.org/docs/ignoring_false_positives/
+const IgnoreFile = "config/brakeman.ignore"
+
+// ignoredWarning is an entry of the ignore file, a warning with a note why it is ignored
+type ignoredWarning struct {
+	WarningInfo
+	Note string `json:"note"`
+}
+
+// AddIgnored adds a warning with a note to the contents of an ignore file and
+// returns the new contents. Empty data starts a new file. The entries and
+// settings already in the file are kept as they are. The warning's File has
+// to be relative to the Rails app. added is false when the warning is ignored
+// already, the contents are returned unchanged then.
+func AddIgnored(data []byte, w WarningInfo, note, brakemanVersion string) (out []byte, added bool, err error) {
+	file := map[string]json.RawMessage{}
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &file); err != nil {
+			return nil, false, fmt.Errorf("Couldn't parse %s: %s", IgnoreFile, err)
+		}
+	}
+
+	var entries []json.RawMessage
+	if raw, ok := file["ignored_warnings"]; ok {
+		if err = json.Unmarshal(raw, &entries); err != nil {
+			return nil, false, fmt.Errorf("Couldn't parse ignored_warnings of %s: %s", IgnoreFile, err)
+		}
+	}
+	for _, raw := range entries {
+		var entry struct {
+			FingerPrint string `json:"fingerprint"`
+		}
+		if err = json.Unmarshal(raw, &entry); err != nil {
+			return nil, false, fmt.Errorf("Couldn't parse ignored warning in %s: %s", IgnoreFile, err)
+		}
+		if entry.FingerPrint == w.FingerPrint {
+			return data, false, nil
+		}
+	}
+
+	entry, err := json.Marshal(ignoredWarning{WarningInfo: w, Note: note})
+	if err != nil {
+		return
+	}
+	entries = append(entries, entry)
+
+	if file["ignored_warnings"], err = json.Marshal(entries); err != nil {
+		return
+	}
+	// the same format brakeman writes when warnings are ignored with brakeman -I
+	file["updated"], _ = json.Marshal(time.Now().Format("2006-01-02 15:04:05 -0700"))
+	if brakemanVersion != "" {
+		file["brakeman_version"], _ = json.Marshal(brakemanVersion)
+	}
+
+	if out, err = json.MarshalIndent(file, "", "  "); err != nil {
+		return
+	}
+	return append(out, '\n'), true, nil
+}
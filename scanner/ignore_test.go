@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package scanner
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// ignoreFile is the part of an ignore file the tests look at
+type ignoreFile struct {
+	IgnoredWarnings []struct {
+		FingerPrint string `json:"fingerprint"`
+		File        string `json:"file"`
+		Note        string `json:"note"`
+	} `json:"ignored_warnings"`
+	BrakemanVersion string `json:"brakeman_version"`
+	Updated         string `json:"updated"`
+	Custom          string `json:"custom"`
+}
+
+func TestAddIgnored(t *testing.T) {
+	w := WarningInfo{FingerPrint: "abc123", WarningType: "SQL Injection", File: "app/models/user.rb", Line: 3}
+
+	tests := []struct {
+		name         string
+		data         string
+		added        bool
+		fingerprints []string
+		custom       string
+	}{
+		{
+			name:         "new file",
+			added:        true,
+			fingerprints: []string{"abc123"},
+		},
+		{
+			name:         "file without entries",
+			data:         `{"custom": "kept"}`,
+			added:        true,
+			fingerprints: []string{"abc123"},
+			custom:       "kept",
+		},
+		{
+			name:         "existing entries are kept",
+			data:         `{"ignored_warnings": [{"fingerprint": "def456", "file": "a.rb", "note": "old"}], "custom": "kept"}`,
+			added:        true,
+			fingerprints: []string{"def456", "abc123"},
+			custom:       "kept",
+		},
+		{
+			name:         "already ignored",
+			data:         `{"ignored_warnings": [{"fingerprint": "abc123", "note": "old"}]}`,
+			fingerprints: []string{"abc123"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, added, err := AddIgnored([]byte(tt.data), w, "false positive", "5.0.0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added != tt.added {
+				t.Errorf("added = %v, want %v", added, tt.added)
+			}
+			if !added && string(out) != tt.data {
+				t.Errorf("AddIgnored() changed the file of an ignored warning: %s", out)
+			}
+
+			var f ignoreFile
+			if err := json.Unmarshal(out, &f); err != nil {
+				t.Fatalf("AddIgnored() returned invalid JSON: %s\n%s", err, out)
+			}
+			var fingerprints []string
+			for _, e := range f.IgnoredWarnings {
+				fingerprints = append(fingerprints, e.FingerPrint)
+			}
+			if strings.Join(fingerprints, ",") != strings.Join(tt.fingerprints, ",") {
+				t.Errorf("fingerprints = %q, want %q", fingerprints, tt.fingerprints)
+			}
+			if f.Custom != tt.custom {
+				t.Errorf("custom = %q, want %q", f.Custom, tt.custom)
+			}
+			if added {
+				last := f.IgnoredWarnings[len(f.IgnoredWarnings)-1]
+				if last.Note != "false positive" || last.File != w.File {
+					t.Errorf("added entry = %+v, want the note and file of the warning", last)
+				}
+				if f.BrakemanVersion != "5.0.0" || f.Updated == "" {
+					t.Errorf("brakeman_version = %q, updated = %q", f.BrakemanVersion, f.Updated)
+				}
+			}
+		})
+	}
+}
+
+func TestAddIgnoredMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "not JSON", data: `ignored_warnings:`},
+		{name: "not an object", data: `[]`},
+		{name: "ignored_warnings not a list", data: `{"ignored_warnings": {"fingerprint": "abc123"}}`},
+		{name: "entry not an object", data: `{"ignored_warnings": ["abc123"]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, added, err := AddIgnored([]byte(tt.data), WarningInfo{FingerPrint: "abc123"}, "note", "")
+			if err == nil {
+				t.Errorf("AddIgnored() = %s, %v, want an error", out, added)
+			}
+		})
+	}
+}
@@ -8,14 +8,14 @@
 package scanner
 
 import (
-
-	// "encoding/json"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
-	// "strings"
+	"path"
+	"path/filepath"
+	"strings"
 )
 
 // ScanInfo is a construct to store scan_info from Findings
@@ -36,66 +36,150 @@ type ScanInfo struct {
 
 // Findings is a construct to store findings from Brakeman
 type Findings struct {
-	ScanInfo        ScanInfo `json:"scan_info,omitempty"`
+	ScanInfo        ScanInfo      `json:"scan_info,omitempty"`
 	Warnings        []WarningInfo `json:"warnings,omitempty"`
-	IgnoredWarnings []string `json:"ignored_warnings,omitempty"`
-	Errors          []string `json:"erros,omitempty"`
-	Obsolete        []string `json:"obsolete,omitempty"`
+	IgnoredWarnings []string      `json:"ignored_warnings,omitempty"`
+	Errors          []string      `json:"erros,omitempty"`
+	Obsolete        []string      `json:"obsolete,omitempty"`
+	// Apps are the Rails apps that were scanned, relative to the scanned folder
+	Apps []string `json:"apps,omitempty"`
 }
 
 type WarningInfo struct {
-	WarningType         string   		`json:"warning_type"`
-	WarningCode         int 	 		`json:"warning_code"`
-	FingerPrint    		string   		`json:"fingerprint"`
-	CheckName           string   		`json:"check_name"`
-	Message             string  	    `json:"message"`
-	File            	string   	    `json:"file"`
-	Line                int			    `json:"line"`
-	Link				string   		`json:"link"`
-	Code      			string   		`json:"code"`
-	Location   			LocationInfo   	`json:"location"`
-	UserInput         	string   		`json:"user_input"`
-	Confidence     	    string   		`json:"confidence"`
+	WarningType string       `json:"warning_type"`
+	WarningCode int          `json:"warning_code"`
+	FingerPrint string       `json:"fingerprint"`
+	CheckName   string       `json:"check_name"`
+	Message     string       `json:"message"`
+	File        string       `json:"file"`
+	Line        int          `json:"line"`
+	Link        string       `json:"link"`
+	Code        string       `json:"code"`
+	Location    LocationInfo `json:"location"`
+	UserInput   string       `json:"user_input"`
+	Confidence  string       `json:"confidence"`
+	// App is the Rails app the warning was found in, relative to the scanned folder
+	App string `json:"app,omitempty"`
 }
 
-type LocationInfo struct{
-	Type         		string   	`json:"type"`
-	Class         		string 	 	`json:"class"`
-	Method    			string   	`json:"method"`
+type LocationInfo struct {
+	Type   string `json:"type"`
+	Class  string `json:"class"`
+	Method string `json:"method"`
+}
 
+// Options changes how a folder is scanned
+type Options struct {
+	// AppPaths are the directories of the Rails apps, relative to the scanned
+	// folder. When empty the apps are discovered, see DiscoverApps.
+	AppPaths []string
+	// SkipFiles are files and directories (ending with a /) brakeman should
+	// not scan, relative to each app
+	SkipFiles []string
+	// ExtraFlags are added to the brakeman command line
+	ExtraFlags []string
 }
 
-// tmp folder holds all the files that need to be scanned
-var tmpFolder = "tmp"
+// Key returns a string identifying the options, scans with the same key
+// produce comparable results
+func (o Options) Key() string {
+	return fmt.Sprintf("app=%s;skip=%s;flags=%s", strings.Join(o.AppPaths, ","), strings.Join(o.SkipFiles, ","), strings.Join(o.ExtraFlags, " "))
+}
 
 // ScanFolder takes a path to a folder to scan, calls the grover binary to do the scan
 // and returns a list of findings, and an error state.
-func ScanFolder(tmpFolder string) (finding Findings, errorBit int, err error) {
+// Every Rails app in the folder is scanned on its own, the warnings of all
+// apps are returned with paths relative to the folder. When there is no app
+// to scan, the errorBit is set and the Errors of the findings say why.
+// The scan is stopped when ctx is cancelled.
+func ScanFolder(ctx context.Context, tmpFolder string, opts Options) (finding Findings, errorBit int, err error) {
 	// this errorBit is set to 1 if there are errors in the execution of brakeman
 	// in case of an error, the Github check is failed but the PR is not blocked
 	errorBit = 0
-	if _, err := os.Stat(tmpFolder + "/app"); !os.IsNotExist(err) {
-		// since Brakeman scans only the app directory, we run the scan only if the app directory exists.
 
-		cmd := exec.Command("./vendor/bundle/bin/brakeman", "-q", "--format", "json", "-p", tmpFolder, "--no-pager", "--no-exit-on-warn", "--no-exit-on-error")
-		stdout, err := cmd.Output()
+	apps := opts.AppPaths
+	if len(apps) == 0 {
+		if apps, err = DiscoverApps(tmpFolder); err != nil {
+			return finding, errorBit, err
+		}
+		if len(apps) == 0 {
+			finding.Errors = append(finding.Errors, "No Rails app was found in the repository. An app is a directory with an app directory along with config/application.rb or a Gemfile depending on rails, app_paths in .ci-brakeman.yml can name the apps.")
+			return finding, 1, nil
+		}
+	}
+
+	for i, app := range apps {
+		app = path.Clean(app)
+		if app == "." {
+			app = ""
+		}
+		appFinding, found, err := scanApp(ctx, tmpFolder, app, opts)
 		if err != nil {
-			log.Fatal(err)
+			return finding, errorBit, err
+		}
+		if !found {
+			// since Brakeman scans only the app directory, we run the scan only if the app directory exists.
+			finding.Errors = append(finding.Errors, fmt.Sprintf("The Rails app %q has no app directory.", "/"+app))
+			return finding, 1, nil
 		}
-		out := []byte(stdout)
 
-		if err := json.Unmarshal(out, &finding); err != nil {
-			fmt.Println(err)
+		if i == 0 {
+			finding.ScanInfo = appFinding.ScanInfo
+		} else {
+			addScanInfo(&finding.ScanInfo, appFinding.ScanInfo)
 		}
-		// Uncomment the checks variable if you need 'Checks Performed' section in the output
-		// append the checks variable to the warnings string
-		// import the strings library 
-		// checks := strings.Join(finding.ScanInfo.ChecksPerformed, ", ")
+		finding.Warnings = append(finding.Warnings, appFinding.Warnings...)
+		finding.IgnoredWarnings = append(finding.IgnoredWarnings, appFinding.IgnoredWarnings...)
+		finding.Errors = append(finding.Errors, appFinding.Errors...)
+		finding.Obsolete = append(finding.Obsolete, appFinding.Obsolete...)
+		finding.Apps = append(finding.Apps, app)
+	}
+	return finding, errorBit, nil
+}
 
-		
+// scanApp runs brakeman on a single Rails app, found is false when the app
+// has no app directory
+func scanApp(ctx context.Context, tmpFolder, app string, opts Options) (finding Findings, found bool, err error) {
+	appFolder := filepath.Join(tmpFolder, filepath.FromSlash(app))
+	if _, err := os.Stat(appFolder + "/app"); os.IsNotExist(err) {
+		return finding, false, nil
+	}
 
-	} else {
-		errorBit = 1
+	args := []string{"-q", "--format", "json", "-p", appFolder, "--no-pager", "--no-exit-on-warn", "--no-exit-on-error"}
+	if len(opts.SkipFiles) > 0 {
+		args = append(args, "--skip-files", strings.Join(opts.SkipFiles, ","))
 	}
-	return finding, errorBit, nil
-}
\ No newline at end of file
+	args = append(args, opts.ExtraFlags...)
+
+	cmd := exec.CommandContext(ctx, "./vendor/bundle/bin/brakeman", args...)
+	stdout, err := cmd.Output()
+	if err != nil {
+		return finding, true, fmt.Errorf("brakeman failed on %s: %s", "/"+app, err)
+	}
+	out := []byte(stdout)
+
+	// output that can't be read would look like a scan without warnings and pass the check
+	if err := json.Unmarshal(out, &finding); err != nil {
+		return finding, true, fmt.Errorf("Couldn't parse the output of brakeman on %s: %s", "/"+app, err)
+	}
+	// brakeman reports paths relative to the app, make them relative to the scanned folder
+	for i := range finding.Warnings {
+		finding.Warnings[i].File = path.Join(app, finding.Warnings[i].File)
+		finding.Warnings[i].App = app
+	}
+	// Uncomment the checks variable if you need 'Checks Performed' section in the output
+	// append the checks variable to the warnings string
+	// checks := strings.Join(finding.ScanInfo.ChecksPerformed, ", ")
+
+	return finding, true, nil
+}
+
+// addScanInfo adds the counts of the scan of another app to the scan info
+func addScanInfo(info *ScanInfo, app ScanInfo) {
+	info.SecurityWarnings += app.SecurityWarnings
+	info.Duration += app.Duration
+	info.NumberOfControllers += app.NumberOfControllers
+	info.NumberOfModels += app.NumberOfModels
+	info.NumberOfTemplates += app.NumberOfTemplates
+	info.EndTime = app.EndTime
+}
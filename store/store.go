@@ -0,0 +1,348 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package store - store
+// Contains a file-backed store keeping the history of all scans
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ci-brakeman/scanner"
+)
+
+// Record holds the outcome of a single scan
+type Record struct {
+	// Event that started the scan: pull_request or push, empty for scans of a base commit
+	Event      string `json:"event,omitempty"`
+	Owner      string `json:"owner"`
+	Repo       string `json:"repo"`
+	PullNumber int    `json:"pull_number,omitempty"`
+	// Ref is the branch that was scanned
+	Ref     string `json:"ref,omitempty"`
+	HeadSHA string `json:"head_sha"`
+	// ScannedSHA is the commit that was checked out and scanned, empty when
+	// only the merge commit of a pull request was scanned
+	ScannedSHA string `json:"scanned_sha,omitempty"`
+	// MergeSHA is the merge commit of a pull request, when it was scanned
+	MergeSHA string `json:"merge_sha,omitempty"`
+	// BaseSHA is the commit the warnings of a pull request were compared with
+	BaseSHA string `json:"base_sha,omitempty"`
+	// CheckRunID is the check run showing the result, empty for scans of a base commit
+	CheckRunID string `json:"check_run_id,omitempty"`
+	// ScanOptions identifies the options the scan ran with, see scanner.Options.Key
+	ScanOptions     string                `json:"scan_options,omitempty"`
+	DeliveryID      string                `json:"delivery_id,omitempty"`
+	BrakemanVersion string                `json:"brakeman_version,omitempty"`
+	ScanInfo        scanner.ScanInfo      `json:"scan_info"`
+	Warnings        []scanner.WarningInfo `json:"warnings"`
+	// NewWarnings is the number of warnings not found in the base commit
+	NewWarnings int `json:"new_warnings"`
+	// Conclusion of the check run, empty for scans that had no check run
+	Conclusion  string    `json:"conclusion"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	// Duration of the whole job in seconds, including cloning the repository
+	Duration float64 `json:"duration"`
+}
+
+// Store keeps scan records in one JSON lines file per repository. Records
+// are only appended, so the files can be used as an audit log, unless a
+// retention is set. The store keeps an index of the records in memory, so a
+// lookup only reads the record it finds. The store has to be the only writer
+// of its files.
+type Store struct {
+	dir string
+	// maxRecords is how many records are kept per repository, 0 keeps all
+	maxRecords int
+
+	mu sync.Mutex
+	// index holds the records of the files read so far, without their warnings
+	index map[string][]entry
+}
+
+// entry is a record in the index along with where it is in its file
+type entry struct {
+	rec    Record
+	offset int64
+	size   int
+}
+
+// Open returns a store writing to the given directory, which is created
+// if it does not exist yet. When maxRecords is more than 0, only that many
+// of the most recent records of a repository are kept.
+func Open(dir string, maxRecords int) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, maxRecords: maxRecords, index: make(map[string][]entry)}, nil
+}
+
+// Save appends a record to the history of its repository
+func (s *Store) Save(rec *Record) error {
+	fp, err := s.path(rec.Owner, rec.Repo)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.load(fp)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fp, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	s.index[fp] = append(entries, entry{rec: indexed(*rec), offset: info.Size(), size: len(data)})
+
+	if s.maxRecords > 0 && len(s.index[fp]) > s.maxRecords {
+		return s.compact(fp)
+	}
+	return nil
+}
+
+// History returns all records of a repository, oldest first
+func (s *Store) History(owner, repo string) (records []Record, err error) {
+	fp, err := s.path(owner, repo)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.load(fp)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		rec, err := s.read(fp, e)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *rec)
+	}
+	return records, nil
+}
+
+// LastPassing returns the most recent record of a repository whose scan
+// succeeded, or nil if there is none
+func (s *Store) LastPassing(owner, repo string) (*Record, error) {
+	return s.last(owner, repo, func(rec *Record) bool {
+		return rec.Conclusion == "success"
+	})
+}
+
+// FindCommit returns the most recent successful scan of the given commit
+// with the given scan options, or nil if there is none. Scans that included
+// the merge commit of a pull request hold warnings of other code than the
+// commit, they are not used.
+func (s *Store) FindCommit(owner, repo, sha, scanOptions string) (*Record, error) {
+	return s.last(owner, repo, func(rec *Record) bool {
+		return rec.HeadSHA == sha && rec.MergeSHA == "" && rec.ScanOptions == scanOptions && rec.Error == "" && rec.ScanInfo.BrakemanVersion != ""
+	})
+}
+
+// Baseline returns the most recent successful scan of a push to the given
+// branch with the given scan options, or nil if there is none
+func (s *Store) Baseline(owner, repo, branch, scanOptions string) (*Record, error) {
+	return s.last(owner, repo, func(rec *Record) bool {
+		return rec.Event == "push" && rec.Ref == branch && rec.ScanOptions == scanOptions && rec.Error == "" && rec.ScanInfo.BrakemanVersion != ""
+	})
+}
+
+// FindCheckRun returns the scan whose result is shown on the given check run,
+// or nil if there is none
+func (s *Store) FindCheckRun(owner, repo, checkRunID string) (*Record, error) {
+	return s.last(owner, repo, func(rec *Record) bool {
+		return rec.CheckRunID == checkRunID
+	})
+}
+
+// LastScan returns the most recent scan of the given commit that got a check
+// run, or nil if there is none
+func (s *Store) LastScan(owner, repo, sha string) (*Record, error) {
+	return s.last(owner, repo, func(rec *Record) bool {
+		return rec.HeadSHA == sha && rec.CheckRunID != ""
+	})
+}
+
+// last returns the most recent record of a repository matching the filter.
+// The filter sees the record without its warnings.
+func (s *Store) last(owner, repo string, match func(*Record) bool) (*Record, error) {
+	fp, err := s.path(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.load(fp)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if match(&entries[i].rec) {
+			return s.read(fp, entries[i])
+		}
+	}
+	return nil, nil
+}
+
+// load returns the index of a file, it is built when the file is first read.
+// s.mu has to be held.
+func (s *Store) load(fp string) ([]entry, error) {
+	if entries, ok := s.index[fp]; ok {
+		return entries, nil
+	}
+
+	f, err := os.Open(fp)
+	if os.IsNotExist(err) {
+		s.index[fp] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []entry
+	var offset int64
+	// a record holds all warnings of a scan, so lines can get long
+	r := bufio.NewReaderSize(f, 64*1024)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			data := bytes.TrimSuffix(line, []byte("\n"))
+			var rec Record
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return nil, fmt.Errorf("Couldn't unmarshal record in %s: %s", fp, err)
+			}
+			entries = append(entries, entry{rec: indexed(rec), offset: offset, size: len(data)})
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.index[fp] = entries
+	return entries, nil
+}
+
+// read returns the full record of an index entry
+func (s *Store) read(fp string, e entry) (*Record, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, e.size)
+	if _, err := f.ReadAt(data, e.offset); err != nil {
+		return nil, fmt.Errorf("Couldn't read record in %s: %s", fp, err)
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("Couldn't unmarshal record in %s: %s", fp, err)
+	}
+	return &rec, nil
+}
+
+// compact rewrites a file with only the most recent maxRecords records.
+// s.mu has to be held.
+func (s *Store) compact(fp string) error {
+	entries := s.index[fp]
+	keep := entries[len(entries)-s.maxRecords:]
+
+	src, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(fp), filepath.Base(fp)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+
+	compacted := make([]entry, 0, len(keep))
+	var offset int64
+	for _, e := range keep {
+		data := make([]byte, e.size+1)
+		if _, err := src.ReadAt(data, e.offset); err != nil {
+			return err
+		}
+		if _, err := tmp.Write(data); err != nil {
+			return err
+		}
+		compacted = append(compacted, entry{rec: e.rec, offset: offset, size: e.size})
+		offset += int64(len(data))
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), fp); err != nil {
+		return err
+	}
+	s.index[fp] = compacted
+	return nil
+}
+
+// indexed returns the record as it is kept in the index, without its warnings
+func indexed(rec Record) Record {
+	rec.Warnings = nil
+	return rec
+}
+
+// path returns the file holding the history of a repository
+func (s *Store) path(owner, repo string) (string, error) {
+	for _, n := range []string{owner, repo} {
+		if n == "" || n == "." || n == ".." || filepath.Base(n) != n {
+			return "", fmt.Errorf("invalid repository name %s/%s", owner, repo)
+		}
+	}
+	return filepath.Join(s.dir, owner, repo+".jsonl"), nil
+}
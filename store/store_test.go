@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ci-brakeman/scanner"
+)
+
+// scan returns a scan of a commit on main that found one warning
+func scan(event, sha, conclusion string) *Record {
+	return &Record{
+		Event:      event,
+		Owner:      "acme",
+		Repo:       "shop",
+		Ref:        "main",
+		HeadSHA:    sha,
+		CheckRunID: "run-" + sha,
+		ScanInfo:   scanner.ScanInfo{BrakemanVersion: "5.1.1"},
+		Warnings:   []scanner.WarningInfo{{FingerPrint: "fp-" + sha, WarningType: "SQL Injection"}},
+		Conclusion: conclusion,
+	}
+}
+
+// shas returns the head SHAs of records
+func shas(records []Record) string {
+	var s []string
+	for _, rec := range records {
+		s = append(s, rec.HeadSHA)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestSaveAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range []*Record{
+		scan("push", "a1", "success"),
+		scan("pull_request", "b2", "failure"),
+		scan("push", "c3", "success"),
+		scan("pull_request", "d4", "neutral"),
+	} {
+		if err := s.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a new store builds its index from the file
+	reopened, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, st := range map[string]*Store{"saved": s, "reopened": reopened} {
+		records, err := st.History("acme", "shop")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := shas(records); got != "a1,b2,c3,d4" {
+			t.Errorf("%s: History() = %s, want a1,b2,c3,d4", name, got)
+		}
+
+		// lookups go through the index and read the warnings back from the file
+		rec, err := st.LastPassing("acme", "shop")
+		if err != nil || rec == nil || rec.HeadSHA != "c3" {
+			t.Fatalf("%s: LastPassing() = %+v, %v, want c3", name, rec, err)
+		}
+		if len(rec.Warnings) != 1 || rec.Warnings[0].FingerPrint != "fp-c3" {
+			t.Errorf("%s: LastPassing() warnings = %+v, want fp-c3", name, rec.Warnings)
+		}
+		if rec, err := st.Baseline("acme", "shop", "main", ""); err != nil || rec == nil || rec.HeadSHA != "c3" {
+			t.Errorf("%s: Baseline() = %+v, %v, want c3", name, rec, err)
+		}
+		if rec, err := st.FindCommit("acme", "shop", "b2", ""); err != nil || rec == nil || rec.Warnings[0].FingerPrint != "fp-b2" {
+			t.Errorf("%s: FindCommit(b2) = %+v, %v", name, rec, err)
+		}
+		if rec, err := st.FindCheckRun("acme", "shop", "run-a1"); err != nil || rec == nil || rec.HeadSHA != "a1" {
+			t.Errorf("%s: FindCheckRun(run-a1) = %+v, %v", name, rec, err)
+		}
+		if rec, err := st.LastScan("acme", "shop", "d4"); err != nil || rec == nil || rec.Conclusion != "neutral" {
+			t.Errorf("%s: LastScan(d4) = %+v, %v", name, rec, err)
+		}
+		if rec, err := st.FindCommit("acme", "shop", "e5", ""); err != nil || rec != nil {
+			t.Errorf("%s: FindCommit(e5) = %+v, %v, want nil", name, rec, err)
+		}
+	}
+
+	// other repositories have no history yet
+	if records, err := reopened.History("acme", "other"); err != nil || len(records) != 0 {
+		t.Errorf("History() of another repository = %v, %v, want none", records, err)
+	}
+}
+
+func TestSaveCompacts(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sha := range []string{"a1", "b2", "c3", "d4", "e5"} {
+		if err := s.Save(scan("push", sha, "success")); err != nil {
+			t.Fatal(err)
+		}
+		// the offsets of the index still match the rewritten file
+		rec, err := s.LastPassing("acme", "shop")
+		if err != nil || rec == nil || rec.HeadSHA != sha || rec.Warnings[0].FingerPrint != "fp-"+sha {
+			t.Fatalf("LastPassing() after saving %s = %+v, %v", sha, rec, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "acme", "shop.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("file holds %d records, want 2", n)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "acme")); len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the history", len(entries))
+	}
+
+	reopened, err := Open(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := reopened.History("acme", "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := shas(records); got != "d4,e5" {
+		t.Errorf("History() = %s, want d4,e5", got)
+	}
+	if rec, err := reopened.FindCommit("acme", "shop", "c3", ""); err != nil || rec != nil {
+		t.Errorf("FindCommit() of a removed record = %+v, %v, want nil", rec, err)
+	}
+}
+
+func TestInvalidRepository(t *testing.T) {
+	s, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range [][2]string{{"acme", ".."}, {"..", "shop"}, {"acme", "a/b"}, {"", "shop"}} {
+		if _, err := s.History(name[0], name[1]); err == nil {
+			t.Errorf("History(%s, %s) didn't fail", name[0], name[1])
+		}
+		rec := scan("push", "a1", "success")
+		rec.Owner, rec.Repo = name[0], name[1]
+		if err := s.Save(rec); err == nil {
+			t.Errorf("Save() to %s/%s didn't fail", name[0], name[1])
+		}
+	}
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+// Package workspace - workspace
+// Contains the logic to hand out an isolated working directory to every scan job
+package workspace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ci-brakeman/logger"
+)
+
+// unsafeChars matches everything we don't want to see in a directory name
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// namePrefix starts the name of every workspace directory, so Sweep only
+// removes directories a manager created even when the root is shared
+const namePrefix = "ci-brakeman-"
+
+// Manager hands out workspaces below a single root directory and keeps
+// track of which job owns which workspace
+type Manager struct {
+	root string
+
+	mu     sync.Mutex
+	owners map[string]string
+}
+
+// Workspace is a directory owned by a single job
+type Workspace struct {
+	// Dir is the path of the directory, files of the job go in here
+	Dir string
+	// Owner identifies the job owning the workspace
+	Owner string
+
+	manager *Manager
+	once    sync.Once
+}
+
+// NewManager creates a manager for the given root directory. The root
+// is created if it does not exist yet.
+func NewManager(root string) (*Manager, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Manager{
+		root:   root,
+		owners: make(map[string]string),
+	}, nil
+}
+
+// Sweep removes the workspace directories in the root that are not owned by a
+// running job. At startup these are the leftovers of jobs that crashed. Other
+// files and directories in the root are left alone.
+func (m *Manager) Sweep() error {
+	entries, err := ioutil.ReadDir(m.root)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), namePrefix) {
+			continue
+		}
+		dir := filepath.Join(m.root, e.Name())
+		if _, ok := m.owners[dir]; ok {
+			continue
+		}
+		logger.CreateBreadcrumb("workspace", fmt.Sprintf("sweeping stale workspace=%s", dir))
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Error(err)
+		}
+	}
+	return nil
+}
+
+// Acquire creates a new, empty workspace for the given owner. The caller
+// has to Release the workspace once done, preferably with a defer so the
+// directory is also removed when the job panics.
+func (m *Manager) Acquire(owner string) (*Workspace, error) {
+	prefix := namePrefix + unsafeChars.ReplaceAllString(owner, "_") + "-"
+	dir, err := ioutil.TempDir(m.root, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.owners[dir] = owner
+	m.mu.Unlock()
+
+	logger.CreateBreadcrumb("workspace", fmt.Sprintf("acquired workspace=%s,owner=%s", dir, owner))
+	return &Workspace{Dir: dir, Owner: owner, manager: m}, nil
+}
+
+// Owners returns a copy of the workspace to owner mapping
+func (m *Manager) Owners() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	owners := make(map[string]string, len(m.owners))
+	for dir, owner := range m.owners {
+		owners[dir] = owner
+	}
+	return owners
+}
+
+// Release removes the workspace and everything in it. It is safe to call
+// Release more than once.
+func (w *Workspace) Release() {
+	w.once.Do(func() {
+		if err := os.RemoveAll(w.Dir); err != nil {
+			logger.Error(err)
+		}
+
+		w.manager.mu.Lock()
+		delete(w.manager.owners, w.Dir)
+		w.manager.mu.Unlock()
+
+		logger.CreateBreadcrumb("workspace", fmt.Sprintf("released workspace=%s,owner=%s", w.Dir, w.Owner))
+	})
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2021, salesforce.com, inc.
+ * All rights reserved.
+ * SPDX-License-Identifier: BSD-3-Clause
+ * For full license text, see the LICENSE file in the repo root or https://opensource.org/licenses/BSD-3-Clause
+ */
+
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSweep(t *testing.T) {
+	root := t.TempDir()
+
+	// files of others sharing the root
+	foreignDir := filepath.Join(root, "data")
+	if err := os.Mkdir(foreignDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	foreignFile := filepath.Join(root, namePrefix+"notes.txt")
+	if err := ioutil.WriteFile(foreignFile, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a workspace of a previous run that crashed
+	previous, err := NewManager(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := previous.Acquire("octo/repo#1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err := m.Acquire("octo/repo#2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer running.Release()
+
+	if err := m.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kept := range []string{foreignDir, foreignFile, running.Dir} {
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("Sweep() removed %s: %s", kept, err)
+		}
+	}
+	if _, err := os.Stat(stale.Dir); !os.IsNotExist(err) {
+		t.Errorf("Sweep() kept the stale workspace %s", stale.Dir)
+	}
+}
+
+func TestAcquireRelease(t *testing.T) {
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := m.Acquire("octo/repo@main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := filepath.Base(w.Dir); !strings.HasPrefix(name, namePrefix+"octo_repo_main-") {
+		t.Errorf("workspace name = %q", name)
+	}
+	if owner := m.Owners()[w.Dir]; owner != "octo/repo@main" {
+		t.Errorf("owner = %q", owner)
+	}
+
+	w.Release()
+	w.Release()
+	if _, err := os.Stat(w.Dir); !os.IsNotExist(err) {
+		t.Errorf("Release() kept %s", w.Dir)
+	}
+	if len(m.Owners()) != 0 {
+		t.Errorf("Owners() = %v after Release", m.Owners())
+	}
+}